@@ -0,0 +1,111 @@
+package cron
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/joetang09/goengineer/webserver"
+)
+
+var (
+	ErrWebServerNotInit = errors.New("webserver not init")
+)
+
+type AdminController struct {
+	GetStats       func(*gin.Context) `path:"/stats"`
+	GetRuns        func(*gin.Context) `path:"/runs"`
+	GetTasks       func(*gin.Context) `path:"/tasks"`
+	GetTask        func(*gin.Context) `path:"/tasks/:name"`
+	PostStart      func(*gin.Context) `path:"/tasks/:name/start"`
+	PostStop       func(*gin.Context) `path:"/tasks/:name/stop"`
+	PostReschedule func(*gin.Context) `path:"/tasks/:name/reschedule"`
+	PostPause      func(*gin.Context) `path:"/tasks/:name/pause"`
+	PostResume     func(*gin.Context) `path:"/tasks/:name/resume"`
+	PostTrigger    func(*gin.Context) `path:"/tasks/:name/trigger"`
+}
+
+type rescheduleRequest struct {
+	RC string `json:"rc" form:"rc" binding:"required"`
+}
+
+func (AdminController) Name() string {
+	return "cron"
+}
+
+func NewAdminController() AdminController {
+	return AdminController{
+		GetStats: func(c *gin.Context) {
+			c.JSON(http.StatusOK, Stats())
+		},
+		GetRuns: func(c *gin.Context) {
+			r, err := Runs()
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, r)
+		},
+		GetTasks: func(c *gin.Context) {
+			c.JSON(http.StatusOK, List())
+		},
+		GetTask: func(c *gin.Context) {
+			t, err := getTask(c.Param("name"))
+			if err != nil {
+				adminError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, t.Info())
+		},
+		PostStart: adminAction(StartTask),
+		PostStop:  adminAction(StopTask),
+		PostReschedule: func(c *gin.Context) {
+			req := rescheduleRequest{}
+			if err := webserver.BindRequest(c, &req); err != nil {
+				adminError(c, err)
+				return
+			}
+			adminAction(func(name string) error {
+				return Reschedule(name, req.RC)
+			})(c)
+		},
+		PostPause:   adminAction(Pause),
+		PostResume:  adminAction(Resume),
+		PostTrigger: adminAction(TriggerNow),
+	}
+}
+
+// RegisterAdmin mounts the cron management API under the given webserver group.
+func RegisterAdmin(path string, middlewares ...gin.HandlerFunc) error {
+	g := webserver.RegisterGroup(path, middlewares...)
+	if g == nil {
+		return ErrWebServerNotInit
+	}
+	g.Controller(NewAdminController())
+	return nil
+}
+
+func adminAction(f func(string) error) func(*gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if err := f(name); err != nil {
+			adminError(c, err)
+			return
+		}
+		t, err := getTask(name)
+		if err != nil {
+			adminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, t.Info())
+	}
+}
+
+func adminError(c *gin.Context, err error) {
+	code := http.StatusBadRequest
+	if err == ErrTaskNotFound {
+		code = http.StatusNotFound
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,97 @@
+package cron_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+)
+
+func adminEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	a := cron.NewAdminController()
+	r := gin.New()
+	r.GET("/stats", a.GetStats)
+	r.GET("/tasks", a.GetTasks)
+	r.GET("/tasks/:name", a.GetTask)
+	r.POST("/tasks/:name/start", a.PostStart)
+	r.POST("/tasks/:name/stop", a.PostStop)
+	r.POST("/tasks/:name/reschedule", a.PostReschedule)
+	r.POST("/tasks/:name/pause", a.PostPause)
+	r.POST("/tasks/:name/resume", a.PostResume)
+	r.POST("/tasks/:name/trigger", a.PostTrigger)
+	return r
+}
+
+func adminDo(t *testing.T, r *gin.Engine, method, path, body string) (int, cron.TaskInfo) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	i := cron.TaskInfo{}
+	if w.Code == http.StatusOK {
+		json.Unmarshal(w.Body.Bytes(), &i)
+	}
+	return w.Code, i
+}
+
+func TestAdminController(t *testing.T) {
+	h := crontest.New(t, testStart)
+	if err := cron.RegisterNamedTask("admin", cron.ModeNormal, func(cron.Args) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{{Name: "admin", RC: "@every 1s"}})
+	r := adminEngine()
+
+	if code, _ := adminDo(t, r, http.MethodGet, "/tasks/missing", ""); code != http.StatusNotFound {
+		t.Fatalf("expect 404, got %d", code)
+	}
+	if code, i := adminDo(t, r, http.MethodGet, "/tasks/admin", ""); code != http.StatusOK || !i.Running {
+		t.Fatalf("expect running task, got %d %+v", code, i)
+	}
+
+	if _, i := adminDo(t, r, http.MethodPost, "/tasks/admin/pause", ""); !i.Paused {
+		t.Fatalf("expect paused, got %+v", i)
+	}
+	h.Advance(3 * time.Second)
+	if i := h.Info("admin"); i.RunNum != 0 {
+		t.Fatalf("expect no run while paused, got %+v", i)
+	}
+	if _, i := adminDo(t, r, http.MethodPost, "/tasks/admin/resume", ""); i.Paused {
+		t.Fatalf("expect resumed, got %+v", i)
+	}
+
+	if code, _ := adminDo(t, r, http.MethodPost, "/tasks/admin/reschedule", `{"rc":"bad"}`); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 on bad rc, got %d", code)
+	}
+	if _, i := adminDo(t, r, http.MethodPost, "/tasks/admin/reschedule", `{"rc":"@every 1h"}`); i.RC != "@every 1h" {
+		t.Fatalf("expect rescheduled, got %+v", i)
+	}
+
+	adminDo(t, r, http.MethodPost, "/tasks/admin/trigger", "")
+	h.Wait()
+	if i := h.Info("admin"); i.SuccessTimes != 1 {
+		t.Fatalf("expect 1 triggered run, got %+v", i)
+	}
+
+	if _, i := adminDo(t, r, http.MethodPost, "/tasks/admin/stop", ""); i.Running {
+		t.Fatalf("expect stopped, got %+v", i)
+	}
+	if code, _ := adminDo(t, r, http.MethodPost, "/tasks/admin/trigger", ""); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 on stopped task, got %d", code)
+	}
+	if _, i := adminDo(t, r, http.MethodPost, "/tasks/admin/start", ""); !i.Running {
+		t.Fatalf("expect started, got %+v", i)
+	}
+}
@@ -0,0 +1,131 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	runIDKey = "_run_id"
+)
+
+type Args map[string]interface{}
+
+func (a Args) RunID() string {
+	id, _ := a[runIDKey].(string)
+	return id
+}
+
+func (a Args) clone() Args {
+	if a == nil {
+		return nil
+	}
+	r := make(Args, len(a))
+	for k, v := range a {
+		r[k] = v
+	}
+	return r
+}
+
+// Heartbeat 上报运行心跳, 仅在任务开启 HeartbeatTimeout 时记录
+func (a Args) Heartbeat() error {
+	return a.Progress(-1, "")
+}
+
+// Progress 上报运行进度并刷新心跳, percent < 0 时保留原进度
+func (a Args) Progress(percent int, msg string) error {
+	return heartbeat(a.RunID(), percent, msg)
+}
+
+// normalize 将 yaml 解析出的 map[interface{}]interface{} 转为 map[string]interface{}, 否则无法 json 序列化
+func (a Args) normalize() Args {
+	if a == nil {
+		return nil
+	}
+	r := make(Args, len(a))
+	for k, v := range a {
+		r[k] = normalizeValue(v)
+	}
+	return r
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[interface{}]interface{}:
+		r := make(map[string]interface{}, len(m))
+		for k, i := range m {
+			r[fmt.Sprintf("%v", k)] = normalizeValue(i)
+		}
+		return r
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(m))
+		for k, i := range m {
+			r[k] = normalizeValue(i)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(m))
+		for k, i := range m {
+			r[k] = normalizeValue(i)
+		}
+		return r
+	}
+	return v
+}
+
+func (a Args) Get(k string) (interface{}, bool) {
+	v, ok := a[k]
+	return v, ok
+}
+
+func (a Args) GetString(k string) string {
+	v, ok := a[k]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (a Args) GetInt64(k string) int64 {
+	v, ok := a[k]
+	if !ok {
+		return 0
+	}
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	i, _ := strconv.ParseInt(fmt.Sprintf("%v", v), 10, 64)
+	return i
+}
+
+func (a Args) GetBool(k string) bool {
+	v, ok := a[k]
+	if !ok {
+		return false
+	}
+	b, _ := strconv.ParseBool(fmt.Sprintf("%v", v))
+	return b
+}
+
+func (a Args) GetStringSlice(k string) []string {
+	v, ok := a[k]
+	if !ok {
+		return nil
+	}
+	switch s := v.(type) {
+	case []string:
+		return s
+	case []interface{}:
+		r := make([]string, 0, len(s))
+		for _, i := range s {
+			r = append(r, fmt.Sprintf("%v", i))
+		}
+		return r
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cron_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+)
+
+func TestConfigArgs(t *testing.T) {
+	h := crontest.New(t, testStart)
+
+	var got cron.Args
+	if err := cron.RegisterHandler("args", cron.ModeNormal, func(a cron.Args) {
+		got = a
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// yaml 解析出的嵌套 map
+	h.Start(cron.Config{{Name: "args_task", Handler: "args", RC: "@every 1s", Args: map[string]interface{}{
+		"n":    1,
+		"ok":   "true",
+		"tags": []interface{}{"a", 2},
+		"nested": map[interface{}]interface{}{
+			"list": []interface{}{map[interface{}]interface{}{1: "x"}},
+		},
+	}}})
+
+	if _, err := json.Marshal(h.Info("args_task")); err != nil {
+		t.Fatalf("expect task info marshalled, got %v", err)
+	}
+
+	h.Advance(time.Second)
+	if got == nil {
+		t.Fatal("expect task run")
+	}
+	if got.GetInt64("n") != 1 || !got.GetBool("ok") || got.RunID() == "" {
+		t.Fatalf("unexpected args %v", got)
+	}
+	if s := got.GetStringSlice("tags"); len(s) != 2 || s[1] != "2" {
+		t.Fatalf("unexpected tags %v", s)
+	}
+	nested, ok := got["nested"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expect nested map normalized, got %T", got["nested"])
+	}
+	item, ok := nested["list"].([]interface{})[0].(map[string]interface{})
+	if !ok || item["1"] != "x" {
+		t.Fatalf("expect map in list normalized, got %v", nested["list"])
+	}
+}
@@ -0,0 +1,96 @@
+package cron
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	clock Clock = realClock{}
+)
+
+// Scheduler 由 robfig/cron 实现, 测试时可替换为 crontest.Scheduler
+type Scheduler interface {
+	Schedule(cron.Schedule, cron.Job) cron.EntryID
+	Remove(cron.EntryID)
+	Entry(cron.EntryID) cron.Entry
+	Start()
+	Stop() context.Context
+}
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+}
+
+// SetScheduler 替换调度器, 已加入 cron 的任务会迁移到新的调度器
+func SetScheduler(s Scheduler) Scheduler {
+	old := cEnginer
+	cEnginer = s
+	tasks.Range(func(k, v interface{}) bool {
+		v.(*Task).setScheduler(s)
+		return true
+	})
+	return old
+}
+
+// Idle 所有任务都没有排队和正在执行的运行
+func Idle() bool {
+	idle := true
+	tasks.Range(func(k, v interface{}) bool {
+		idle = v.(*Task).idle()
+		return idle
+	})
+	return idle
+}
+
+// Reset 停止并注销所有任务与处理函数, 用于测试
+func Reset() {
+	tasks.Range(func(k, v interface{}) bool {
+		v.(*Task).Stop()
+		tasks.Delete(k)
+		return true
+	})
+	handlers.Range(func(k, v interface{}) bool {
+		handlers.Delete(k)
+		return true
+	})
+
+	flowMu.Lock()
+	depends = map[string]*depend{}
+	downs = map[string][]string{}
+	flowRuns = map[string]*flowRun{}
+	flowMu.Unlock()
+
+	jobEntries.Range(func(k, v interface{}) bool {
+		jobEntries.Delete(k)
+		return true
+	})
+	jobRunning.Range(func(k, v interface{}) bool {
+		jobRunning.Delete(k)
+		return true
+	})
+	trackedRuns.Range(func(k, v interface{}) bool {
+		trackedRuns.Delete(k)
+		return true
+	})
+	stopWatchdog()
+	SetJobStore(nil)
+	SetRunStore(nil)
+
+	config = nil
+}
@@ -5,30 +5,57 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/robfig/cron"
+	"github.com/robfig/cron/v3"
 )
 
 var (
-	cEnginer = cron.New()
+	parser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 
-	tasks sync.Map
+	cEnginer Scheduler = cron.New(cron.WithParser(parser))
 
-	ErrTaskNotFound  = errors.New("task not found")
-	ErrDuplicateTask = errors.New("duplicate task")
-	ErrTaskInCron    = errors.New("task is in cron")
+	tasks    sync.Map
+	handlers sync.Map
+
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrDuplicateTask    = errors.New("duplicate task")
+	ErrTaskInCron       = errors.New("task is in cron")
+	ErrTaskNotRunning   = errors.New("task is not running")
+	ErrHandlerNotFound  = errors.New("handler not found")
+	ErrDuplicateHandler = errors.New("duplicate handler")
 
 	config Config
 )
 
 type CronTaskItem struct {
-	Name string
-	RC   string
+	Name      string
+	Handler   string // create task Name from a registered handler
+	Args      map[string]interface{}
+	RC        string
+	TZ        string   // e.g. Asia/Shanghai, default is local
+	Calendars []string // names registered by RegisterCalendar
+	Exclude   []string // 2006-01-02 or 01-02
+	Jitter    int      // in second
+	After     []string // run after these tasks succeed in the same run
+	Policy    string   // skip, fail or run when an upstream failed
+
+	MaxConcurrency int    // max running instances in parallel mode, 0 is unlimited
+	MaxQueue       int    // max queued runs, 0 is unlimited
+	Overflow       string // drop, drop-oldest or log-and-skip
+
+	HeartbeatTimeout int // in second, persist runs and detect abandoned ones if > 0
 }
 
 type Config []CronTaskItem
 
+type handler struct {
+	m mode
+	f func(Args)
+}
+
 type Cpnt struct {
 }
 
@@ -43,7 +70,11 @@ func (Cpnt) Init(options ...interface{}) error {
 		return nil
 	}
 
-	config = *c
+	config = make(Config, len(*c))
+	for i, tc := range *c {
+		tc.Args = Args(tc.Args).normalize()
+		config[i] = tc
+	}
 
 	return nil
 }
@@ -61,16 +92,54 @@ func (Cpnt) CfgUpdate(interface{}) {
 func Start() error {
 
 	for _, tc := range config {
+		t, err := configTask(tc)
+		if err != nil {
+			return err
+		}
+		if err := t.SetLimit(tc.MaxConcurrency, tc.MaxQueue, tc.Overflow); err != nil {
+			return err
+		}
+		t.SetHeartbeat(time.Duration(tc.HeartbeatTimeout) * time.Second)
+	}
+	for _, tc := range config {
+		if len(tc.After) == 0 {
+			continue
+		}
+		if err := SetDepends(tc.Name, tc.After, tc.Policy); err != nil {
+			return err
+		}
+	}
+	if err := checkFlows(); err != nil {
+		return err
+	}
+	if err := LoadJobs(); err != nil {
+		return err
+	}
+	startWatchdog()
 
-		val, ok := tasks.Load(tc.Name)
-		if !ok {
-			return ErrTaskNotFound
+	for _, tc := range config {
+
+		t, err := getTask(tc.Name)
+		if err != nil {
+			return err
 		}
-		t := val.(*Task)
-		if t.inCron {
+		if t.isRunning() {
+			continue
+		}
+
+		if isDownstream(tc.Name) {
+			t.StartTriggered()
 			continue
 		}
 
+		ops, err := itemOptions(tc)
+		if err != nil {
+			return err
+		}
+		if err := t.SetOptions(ops...); err != nil {
+			return err
+		}
+
 		if tc.RC != "" {
 			if err := t.StartWithRC(tc.RC); err != nil {
 				return err
@@ -87,39 +156,161 @@ func Start() error {
 
 func StopTask(name string) error {
 
-	val, ok := tasks.Load(name)
-	if !ok {
-		return ErrTaskNotFound
+	t, err := getTask(name)
+	if err != nil {
+		return err
 	}
-	t := val.(*Task)
 	t.Stop()
 	return nil
 }
 
 func StartTask(name string) error {
 
-	val, ok := tasks.Load(name)
-	if !ok {
-		return ErrTaskNotFound
+	t, err := getTask(name)
+	if err != nil {
+		return err
 	}
 
-	return val.(*Task).Start()
+	return t.Start()
 }
 
 func StartTaskWithRC(name, rc string) error {
-	val, ok := tasks.Load(name)
-	if !ok {
-		return ErrTaskNotFound
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+
+	return t.StartWithRC(rc)
+}
+
+func SetTaskOptions(name string, ops ...ScheduleOption) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+	return t.SetOptions(ops...)
+}
+
+func SetTaskLimit(name string, maxConcurrency, maxQueue int, overflow string) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+	return t.SetLimit(maxConcurrency, maxQueue, overflow)
+}
+
+func List() []TaskInfo {
+
+	r := []TaskInfo{}
+	tasks.Range(func(k, v interface{}) bool {
+		r = append(r, v.(*Task).Info())
+		return true
+	})
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r
+}
+
+func Reschedule(name, rc string) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+	return t.Reschedule(rc)
+}
+
+func Pause(name string) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
 	}
+	t.Pause()
+	return nil
+}
 
-	return val.(*Task).StartWithRC(rc)
+func Resume(name string) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+	t.Resume()
+	return nil
+}
+
+func TriggerNow(name string) error {
+	t, err := getTask(name)
+	if err != nil {
+		return err
+	}
+	return t.TriggerNow()
 }
 
 func RegisterTask(m mode, f func()) string {
 
-	t := newTask(runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name(), m, f, cEnginer)
+	t := newTask(runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name(), m, func(Args) { f() }, nil, cEnginer)
 
 	tasks.Store(t.name, t)
 	fmt.Println("[cron] Register Task : ", t.name)
 	return t.name
 }
+
+func RegisterNamedTask(name string, m mode, f func(Args), args Args) error {
+
+	t := newTask(name, m, f, args, cEnginer)
+
+	if _, ok := tasks.LoadOrStore(name, t); ok {
+		return ErrDuplicateTask
+	}
+	fmt.Println("[cron] Register Task : ", name)
+	return nil
+}
+
+func RegisterHandler(name string, m mode, f func(Args)) error {
+
+	if _, ok := handlers.LoadOrStore(name, &handler{m: m, f: f}); ok {
+		return ErrDuplicateHandler
+	}
+	fmt.Println("[cron] Register Handler : ", name)
+	return nil
+}
+
+func NewTaskFromHandler(name, handlerName string, args Args) error {
+
+	val, ok := handlers.Load(handlerName)
+	if !ok {
+		return ErrHandlerNotFound
+	}
+	h := val.(*handler)
+	t := newTask(name, h.m, h.f, args, cEnginer)
+	t.handler = handlerName
+
+	if _, ok := tasks.LoadOrStore(name, t); ok {
+		return ErrDuplicateTask
+	}
+	fmt.Println("[cron] Register Task : ", name, " with handler ", handlerName)
+	return nil
+}
+
+func configTask(tc CronTaskItem) (*Task, error) {
+	if tc.Handler == "" {
+		return getTask(tc.Name)
+	}
+
+	if t, err := getTask(tc.Name); err == nil {
+		if t.handler != tc.Handler {
+			return nil, ErrDuplicateTask
+		}
+		return t, nil
+	}
+	if err := NewTaskFromHandler(tc.Name, tc.Handler, tc.Args); err != nil {
+		return nil, err
+	}
+	return getTask(tc.Name)
+}
+
+func getTask(name string) (*Task, error) {
+	val, ok := tasks.Load(name)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return val.(*Task), nil
+}
@@ -0,0 +1,217 @@
+package cron
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	delayTaskPrefix = "delay:"
+
+	// 已过期的延时任务在此之后执行
+	pastDueDelay = time.Second
+	// 执行中的延时任务超过此时间没有刷新时认为进程已崩溃, 重新放回等待执行
+	jobStaleTimeout = time.Minute
+)
+
+var (
+	jobStore JobStore = NewMemJobStore()
+
+	// 已加入调度器的延时任务, id -> cron.EntryID
+	jobEntries sync.Map
+	// 正在执行的延时任务
+	jobRunning sync.Map
+
+	jobSeq uint64
+
+	ErrJobNotFound = errors.New("job not found")
+)
+
+func SetJobStore(s JobStore) {
+	if s == nil {
+		s = NewMemJobStore()
+	}
+	jobStore = s
+}
+
+type onceSchedule struct {
+	at time.Time
+}
+
+func (o onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(o.at) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+type delayedJob struct {
+	id      string
+	handler string
+	args    Args
+}
+
+func (d *delayedJob) Run() {
+	if e, ok := jobEntries.Load(d.id); ok {
+		jobEntries.Delete(d.id)
+		cEnginer.Remove(e.(cron.EntryID))
+	}
+
+	ok, err := jobStore.Claim(d.id, clock.Now().Unix())
+	if err != nil {
+		fmt.Println("[cron] Claim Job Failed : ", d.id, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	t, err := delayTask(d.handler)
+	if err != nil {
+		fmt.Println("[cron] Job Handler Not Found : ", d.id, d.handler)
+		jobStore.Finish(d.id, JobFailed)
+		return
+	}
+
+	// 已接受的延时任务不受运行模式与队列长度限制, 总是排队执行
+	jobRunning.Store(d.id, struct{}{})
+	t.enqueue(d.id, d.args)
+}
+
+func newJobID() string {
+	return fmt.Sprintf("job-%x-%x", clock.Now().UnixNano(), atomic.AddUint64(&jobSeq, 1))
+}
+
+// delayTask 每个处理函数对应一个执行延时任务的 Task, 共用其运行模式与并发限制
+func delayTask(handlerName string) (*Task, error) {
+	name := delayTaskPrefix + handlerName
+	if t, err := getTask(name); err == nil {
+		return t, nil
+	}
+
+	val, ok := handlers.Load(handlerName)
+	if !ok {
+		return nil, ErrHandlerNotFound
+	}
+	h := val.(*handler)
+	t := newTask(name, h.m, h.f, nil, cEnginer)
+	t.handler = handlerName
+	if v, loaded := tasks.LoadOrStore(name, t); loaded {
+		t = v.(*Task)
+	}
+	t.StartTriggered()
+	return t, nil
+}
+
+func scheduleJob(j *delayedJob, at time.Time) {
+	now := clock.Now()
+	if !at.After(now) {
+		at = now.Add(pastDueDelay)
+	}
+	jobEntries.Store(j.id, cEnginer.Schedule(onceSchedule{at}, j))
+}
+
+// Schedule 在 at 时执行一次注册的处理函数 handler
+func Schedule(at time.Time, handlerName string, args Args) (string, error) {
+	if _, ok := handlers.Load(handlerName); !ok {
+		return "", ErrHandlerNotFound
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	j := &delayedJob{id: newJobID(), handler: handlerName, args: args.clone()}
+	if err := jobStore.Save(DelayedJob{
+		ID:      j.id,
+		Handler: handlerName,
+		Args:    string(data),
+		RunAt:   at.Unix(),
+		Status:  JobPending,
+	}); err != nil {
+		return "", err
+	}
+	scheduleJob(j, at)
+	return j.id, nil
+}
+
+// After 在 d 之后执行一次注册的处理函数 handler
+func After(d time.Duration, handlerName string, args Args) (string, error) {
+	return Schedule(clock.Now().Add(d), handlerName, args)
+}
+
+func Cancel(id string) error {
+	ok, err := jobStore.Cancel(id)
+	if err != nil {
+		return err
+	}
+	if e, loaded := jobEntries.Load(id); loaded {
+		jobEntries.Delete(id)
+		cEnginer.Remove(e.(cron.EntryID))
+		ok = true
+	}
+	if !ok {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// LoadJobs 从 JobStore 恢复未执行的延时任务, 已过期的尽快执行
+// 崩溃的进程遗留的执行中任务超过 jobStaleTimeout 后重新执行
+func LoadJobs() error {
+	if _, err := jobStore.Requeue(clock.Now().Add(-jobStaleTimeout).Unix()); err != nil {
+		return err
+	}
+	js, err := jobStore.Pending()
+	if err != nil {
+		return err
+	}
+	for _, j := range js {
+		if _, ok := jobEntries.Load(j.ID); ok {
+			continue
+		}
+		args := Args{}
+		if j.Args != "" {
+			if err := json.Unmarshal([]byte(j.Args), &args); err != nil {
+				return err
+			}
+		}
+		scheduleJob(&delayedJob{id: j.ID, handler: j.Handler, args: args}, time.Unix(j.RunAt, 0))
+	}
+	return nil
+}
+
+// touchJobs 刷新本进程执行中的延时任务, 由 watchdog 定时调用
+func touchJobs() error {
+	ids := []string{}
+	jobRunning.Range(func(k, v interface{}) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	return jobStore.Touch(ids, clock.Now().Unix())
+}
+
+// jobDone 被跳过的任务 (任务被停止) 放回等待执行, 由 LoadJobs 重新加载
+func jobDone(id string, r result) {
+	if _, ok := jobRunning.Load(id); !ok {
+		return
+	}
+	jobRunning.Delete(id)
+
+	status := JobDone
+	switch r {
+	case resultFailed:
+		status = JobFailed
+	case resultSkipped:
+		status = JobPending
+	}
+	if err := jobStore.Finish(id, status); err != nil {
+		fmt.Println("[cron] Finish Job Failed : ", id, err)
+	}
+}
@@ -0,0 +1,192 @@
+package cron_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+	"github.com/joetang09/goengineer/db"
+	"github.com/joetang09/goengineer/dbtest"
+)
+
+type orderLog struct {
+	mu     sync.Mutex
+	orders []int64
+}
+
+func (l *orderLog) add(a cron.Args) {
+	l.mu.Lock()
+	l.orders = append(l.orders, a.GetInt64("order"))
+	l.mu.Unlock()
+}
+
+func (l *orderLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.orders)
+}
+
+func TestDelayedJobNotSkipped(t *testing.T) {
+	h := crontest.New(t, testStart)
+	b := newBlockingTask()
+	l := &orderLog{}
+	if err := cron.RegisterHandler("busy", cron.ModeNormal, func(a cron.Args) {
+		l.add(a)
+		b.run(a)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{})
+
+	if _, err := cron.After(time.Second, "busy", cron.Args{"order": 1}); err != nil {
+		t.Fatal(err)
+	}
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+
+	// normal 模式与队列上限都不丢弃已经接受的延时任务
+	if err := cron.SetTaskLimit("delay:busy", 0, 1, cron.OverflowDrop); err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i <= 4; i++ {
+		if _, err := cron.After(time.Second, "busy", cron.Args{"order": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Tick(time.Second)
+	close(b.release)
+	h.Wait()
+
+	if n := l.len(); n != 4 {
+		t.Fatalf("expect 4 jobs run, got %d", n)
+	}
+	if i := h.Info("delay:busy"); i.SkipTimes != 0 || i.DropTimes != 0 || !i.Next.IsZero() {
+		t.Fatalf("expect no skipped job and not in cron, got %+v", i)
+	}
+}
+
+func TestDelayedJobStopped(t *testing.T) {
+	h := crontest.New(t, testStart)
+	b := newBlockingTask()
+	l := &orderLog{}
+	if err := cron.RegisterHandler("stopped", cron.ModeWaiting, func(a cron.Args) {
+		l.add(a)
+		b.run(a)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s := cron.NewMemJobStore()
+	cron.SetJobStore(s)
+	h.Start(cron.Config{})
+
+	cron.After(time.Second, "stopped", cron.Args{"order": 1})
+	cron.After(time.Second, "stopped", cron.Args{"order": 2})
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+
+	// 停止时排队的任务放回等待执行
+	if err := cron.StopTask("delay:stopped"); err != nil {
+		t.Fatal(err)
+	}
+	close(b.release)
+	h.Wait()
+	deadline := time.Now().Add(time.Second)
+	for js, _ := s.Pending(); len(js) != 1; js, _ = s.Pending() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the queued job released, got %+v", js)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := cron.StartTask("delay:stopped"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.LoadJobs(); err != nil {
+		t.Fatal(err)
+	}
+	h.Advance(time.Second)
+	if n := l.len(); n != 2 {
+		t.Fatalf("expect the queued job run after restart, got %d", n)
+	}
+}
+
+func TestDelayedJobCrashRecovery(t *testing.T) {
+	h := crontest.New(t, testStart)
+	l := &orderLog{}
+	if err := cron.RegisterHandler("recover", cron.ModeWaiting, l.add); err != nil {
+		t.Fatal(err)
+	}
+
+	// 崩溃前已领取但没有完成的任务
+	s := cron.NewMemJobStore()
+	s.Save(cron.DelayedJob{ID: "stale", Handler: "recover", Args: `{"order":1}`, RunAt: testStart.Unix(),
+		Status: cron.JobRunning, UpdateTime: int(testStart.Add(-time.Hour).Unix())})
+	s.Save(cron.DelayedJob{ID: "fresh", Handler: "recover", Args: `{"order":2}`, RunAt: testStart.Unix(),
+		Status: cron.JobRunning, UpdateTime: int(testStart.Unix())})
+	cron.SetJobStore(s)
+	h.Start(cron.Config{})
+
+	h.Advance(2 * time.Second)
+	if n := l.len(); n != 1 {
+		t.Fatalf("expect the stale job rerun on start, got %d", n)
+	}
+
+	h.Advance(time.Minute)
+	if err := cron.LoadJobs(); err != nil {
+		t.Fatal(err)
+	}
+	h.Advance(2 * time.Second)
+	if n := l.len(); n != 2 {
+		t.Fatalf("expect the job rerun after stale timeout, got %d", n)
+	}
+	if js, _ := s.Pending(); len(js) != 0 {
+		t.Fatalf("expect no pending job, got %+v", js)
+	}
+}
+
+func TestDBJobStore(t *testing.T) {
+	// 时间戳回调不写入 update_time
+	dbtest.Open(t, "cron_job", db.ConnConfig{TimeFormat: "millis"})
+	s, err := cron.NewDBJobStoreByName("cron_job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Save(cron.DelayedJob{ID: "j1", Handler: "h", Args: "{}", RunAt: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Claim("j1", 100); !ok || err != nil {
+		t.Fatalf("expect claimed, got %v %v", ok, err)
+	}
+	if ok, _ := s.Claim("j1", 100); ok {
+		t.Fatal("expect claimed only once")
+	}
+	s.Touch([]string{"j1"}, 200)
+	if n, _ := s.Requeue(150); n != 0 {
+		t.Fatalf("expect touched job kept running, got %d", n)
+	}
+	if n, _ := s.Requeue(250); n != 1 {
+		t.Fatalf("expect stale job requeued, got %d", n)
+	}
+	if js, _ := s.Pending(); len(js) != 1 {
+		t.Fatalf("expect 1 pending job, got %+v", js)
+	}
+
+	s.Claim("j1", 300)
+	if err := s.Finish("j1", cron.JobPending); err != nil {
+		t.Fatal(err)
+	}
+	if js, _ := s.Pending(); len(js) != 1 {
+		t.Fatalf("expect job released, got %+v", js)
+	}
+	s.Claim("j1", 400)
+	s.Finish("j1", cron.JobDone)
+	if js, _ := s.Pending(); len(js) != 0 {
+		t.Fatalf("expect no pending job, got %+v", js)
+	}
+	j := cron.DelayedJob{}
+	if err := db.MustWrite("cron_job").Where("id = ?", "j1").First(&j).Error; err != nil || j.UpdateTime != 400 {
+		t.Fatalf("expect update_time kept from Claim, got %+v %v", j, err)
+	}
+}
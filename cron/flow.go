@@ -0,0 +1,306 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+
+任务依赖
+
+通过 CronTaskItem.After 声明任务在上游任务之后执行, 多个上游时需全部完成 (fan-in)
+没有上游的任务为根任务, 每次被 cron 或手动触发时生成新的运行 ID
+下游任务在同一运行 ID 下触发, 下游任务本身不加入 cron
+
+fan-in 的所有上游必须来自相同的根任务, 独立调度的任务请声明一个共同的上游
+
+上游失败 (panic 或被跳过) 时下游按 Policy 处理
+
+skip  跳过下游任务, 继续向后传递为失败 (默认)
+fail  下游任务记为失败
+run   忽略上游结果, 继续执行下游任务
+
+*/
+
+const (
+	PolicySkip = "skip"
+	PolicyFail = "fail"
+	PolicyRun  = "run"
+)
+
+const (
+	resultSuccess result = iota
+	resultFailed
+	resultSkipped
+)
+
+var (
+	flowMu   sync.Mutex
+	depends  = map[string]*depend{}
+	downs    = map[string][]string{}
+	flowRuns = map[string]*flowRun{}
+
+	runSeq uint64
+
+	ErrDependCycle    = errors.New("task depend cycle")
+	ErrPolicy         = errors.New("unknown depend policy")
+	ErrFanInNoRoot    = errors.New("fan-in upstreams must share the same root tasks")
+	ErrDependOnItself = errors.New("task depend on itself")
+	ErrDownstreamTask = errors.New("task runs after upstream tasks")
+)
+
+type result byte
+
+type depend struct {
+	after  []string
+	policy string
+}
+
+type flowRun struct {
+	results map[string]result
+	pending map[string]struct{}
+}
+
+// newRunID 包含进程标识, 多个进程同时触发同一任务时不重复
+func newRunID(name string) string {
+	return fmt.Sprintf("%s-%s-%x-%x", name, ownerTag, clock.Now().Unix(), atomic.AddUint64(&runSeq, 1))
+}
+
+func SetDepends(name string, after []string, policy string) error {
+	if policy == "" {
+		policy = PolicySkip
+	}
+	if policy != PolicySkip && policy != PolicyFail && policy != PolicyRun {
+		return ErrPolicy
+	}
+	if _, err := getTask(name); err != nil {
+		return err
+	}
+	for _, a := range after {
+		if a == name {
+			return ErrDependOnItself
+		}
+		if _, err := getTask(a); err != nil {
+			return err
+		}
+	}
+
+	flowMu.Lock()
+	defer flowMu.Unlock()
+
+	old := depends[name]
+	removeDepend(name)
+	if len(after) > 0 {
+		depends[name] = &depend{after: append([]string{}, after...), policy: policy}
+		for _, a := range after {
+			downs[a] = append(downs[a], name)
+		}
+	}
+	if hasCycle(name) {
+		removeDepend(name)
+		if old != nil {
+			depends[name] = old
+			for _, a := range old.after {
+				downs[a] = append(downs[a], name)
+			}
+		}
+		return ErrDependCycle
+	}
+	return nil
+}
+
+func removeDepend(name string) {
+	d, ok := depends[name]
+	if !ok {
+		return
+	}
+	for _, a := range d.after {
+		ds := downs[a][:0]
+		for _, n := range downs[a] {
+			if n != name {
+				ds = append(ds, n)
+			}
+		}
+		if len(ds) == 0 {
+			delete(downs, a)
+		} else {
+			downs[a] = ds
+		}
+	}
+	delete(depends, name)
+}
+
+func hasCycle(name string) bool {
+	seen := map[string]bool{}
+	stack := []string{name}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range downs[n] {
+			if d == name {
+				return true
+			}
+			if !seen[d] {
+				seen[d] = true
+				stack = append(stack, d)
+			}
+		}
+	}
+	return false
+}
+
+func dependsOf(name string) ([]string, string) {
+	flowMu.Lock()
+	defer flowMu.Unlock()
+
+	d, ok := depends[name]
+	if !ok {
+		return nil, ""
+	}
+	return append([]string{}, d.after...), d.policy
+}
+
+func isDownstream(name string) bool {
+	flowMu.Lock()
+	defer flowMu.Unlock()
+
+	_, ok := depends[name]
+	return ok
+}
+
+func rootsOf(name string, cache map[string]map[string]struct{}) map[string]struct{} {
+	if r, ok := cache[name]; ok {
+		return r
+	}
+	r := map[string]struct{}{}
+	d, ok := depends[name]
+	if !ok {
+		r[name] = struct{}{}
+	} else {
+		for _, a := range d.after {
+			for k := range rootsOf(a, cache) {
+				r[k] = struct{}{}
+			}
+		}
+	}
+	cache[name] = r
+	return r
+}
+
+// checkFlows 检查 fan-in 的上游是否来自相同的根任务
+func checkFlows() error {
+	flowMu.Lock()
+	defer flowMu.Unlock()
+
+	cache := map[string]map[string]struct{}{}
+	for name, d := range depends {
+		roots := rootsOf(name, cache)
+		for _, a := range d.after {
+			if len(rootsOf(a, cache)) != len(roots) {
+				return fmt.Errorf("%w : %s", ErrFanInNoRoot, name)
+			}
+		}
+	}
+	return nil
+}
+
+func newRun(root string) string {
+	id := newRunID(root)
+
+	flowMu.Lock()
+	defer flowMu.Unlock()
+
+	if _, ok := downs[root]; !ok {
+		return id
+	}
+	fr := &flowRun{
+		results: map[string]result{},
+		pending: map[string]struct{}{root: {}},
+	}
+	stack := []string{root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range downs[n] {
+			if _, ok := fr.pending[d]; !ok {
+				fr.pending[d] = struct{}{}
+				stack = append(stack, d)
+			}
+		}
+	}
+	flowRuns[id] = fr
+	return id
+}
+
+func dropRun(id string) {
+	flowMu.Lock()
+	delete(flowRuns, id)
+	flowMu.Unlock()
+}
+
+func flowDone(name, id string, r result) {
+
+	type next struct {
+		name string
+		ok   bool
+		d    *depend
+	}
+
+	flowMu.Lock()
+	fr, ok := flowRuns[id]
+	if !ok {
+		flowMu.Unlock()
+		return
+	}
+	fr.results[name] = r
+	delete(fr.pending, name)
+
+	nexts := []next{}
+	for _, dn := range downs[name] {
+		if _, ok := fr.pending[dn]; !ok {
+			continue
+		}
+		d := depends[dn]
+		ready, allOK := true, true
+		for _, a := range d.after {
+			ar, ok := fr.results[a]
+			if !ok {
+				ready = false
+				break
+			}
+			if ar != resultSuccess {
+				allOK = false
+			}
+		}
+		if ready {
+			nexts = append(nexts, next{dn, allOK, d})
+		}
+	}
+	if len(fr.pending) == 0 {
+		delete(flowRuns, id)
+	}
+	flowMu.Unlock()
+
+	for _, n := range nexts {
+		t, err := getTask(n.name)
+		if err != nil {
+			flowDone(n.name, id, resultSkipped)
+			continue
+		}
+		if !n.ok && n.d.policy != PolicyRun {
+			t.upstreamFailed()
+			if n.d.policy == PolicyFail {
+				flowDone(n.name, id, resultFailed)
+			} else {
+				flowDone(n.name, id, resultSkipped)
+			}
+			continue
+		}
+		if !t.trigger(id) {
+			flowDone(n.name, id, resultSkipped)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package cron_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+)
+
+// runLog 记录每个任务运行时的运行 ID
+type runLog struct {
+	mu   sync.Mutex
+	runs map[string][]string
+}
+
+func (l *runLog) task(name string, fail bool) func(cron.Args) {
+	return func(a cron.Args) {
+		l.mu.Lock()
+		if l.runs == nil {
+			l.runs = map[string][]string{}
+		}
+		l.runs[name] = append(l.runs[name], a.RunID())
+		l.mu.Unlock()
+		if fail {
+			panic(name)
+		}
+	}
+}
+
+func (l *runLog) get(name string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.runs[name]
+}
+
+func registerFlow(t *testing.T, l *runLog, fail map[string]bool, names ...string) {
+	t.Helper()
+	for _, n := range names {
+		if err := cron.RegisterNamedTask(n, cron.ModeWaiting, l.task(n, fail[n]), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFlowChain(t *testing.T) {
+	h := crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, nil, "chain_a", "chain_b", "chain_c")
+	h.Start(cron.Config{
+		{Name: "chain_a", RC: "@every 1s"},
+		{Name: "chain_b", After: []string{"chain_a"}},
+		{Name: "chain_c", After: []string{"chain_b"}},
+	})
+
+	if i := h.Info("chain_b"); !i.Running || !i.Next.IsZero() {
+		t.Fatalf("expect downstream running but not in cron, got %+v", i)
+	}
+	h.Advance(3 * time.Second)
+
+	a, b, c := l.get("chain_a"), l.get("chain_b"), l.get("chain_c")
+	if len(a) != 3 || len(b) != 3 || len(c) != 3 {
+		t.Fatalf("expect 3 runs each, got %v %v %v", a, b, c)
+	}
+	for i := range a {
+		if a[i] != b[i] || b[i] != c[i] {
+			t.Fatalf("expect same run id in a flow, got %s %s %s", a[i], b[i], c[i])
+		}
+	}
+}
+
+func TestFlowStartDownstream(t *testing.T) {
+	h := crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, nil, "start_a", "start_b")
+	h.Start(cron.Config{
+		{Name: "start_a", RC: "@every 1m"},
+		{Name: "start_b", After: []string{"start_a"}},
+	})
+
+	if err := cron.StopTask("start_b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.StartTask("start_b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.StartTaskWithRC("start_b", "@every 1s"); !errors.Is(err, cron.ErrDownstreamTask) {
+		t.Fatalf("expect ErrDownstreamTask, got %v", err)
+	}
+
+	// 下游任务不按默认的每秒执行, 只随上游执行
+	h.Advance(time.Minute)
+	if b := l.get("start_b"); len(b) != 1 {
+		t.Fatalf("expect downstream run once with upstream, got %v", b)
+	}
+}
+
+func TestFlowFanIn(t *testing.T) {
+	h := crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, nil, "fan_a", "fan_b", "fan_c", "fan_d")
+	h.Start(cron.Config{
+		{Name: "fan_a", RC: "@every 1s"},
+		{Name: "fan_b", After: []string{"fan_a"}},
+		{Name: "fan_c", After: []string{"fan_a"}},
+		{Name: "fan_d", After: []string{"fan_b", "fan_c"}},
+	})
+
+	h.Advance(2 * time.Second)
+	if d := l.get("fan_d"); len(d) != 2 {
+		t.Fatalf("expect fan-in run once per flow, got %v", d)
+	}
+}
+
+func TestFlowPolicy(t *testing.T) {
+	h := crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, map[string]bool{"policy_root": true}, "policy_root", "policy_skip", "policy_fail", "policy_run", "policy_after_skip")
+	h.Start(cron.Config{
+		{Name: "policy_root", RC: "@every 1s"},
+		{Name: "policy_skip", After: []string{"policy_root"}},
+		{Name: "policy_fail", After: []string{"policy_root"}, Policy: cron.PolicyFail},
+		{Name: "policy_run", After: []string{"policy_root"}, Policy: cron.PolicyRun},
+		{Name: "policy_after_skip", After: []string{"policy_skip"}, Policy: cron.PolicyFail},
+	})
+
+	h.Advance(time.Second)
+	if len(l.get("policy_skip")) != 0 || len(l.get("policy_fail")) != 0 || len(l.get("policy_after_skip")) != 0 {
+		t.Fatal("expect downstream of a failed task not run")
+	}
+	if len(l.get("policy_run")) != 1 {
+		t.Fatal("expect downstream with run policy run")
+	}
+	for _, n := range []string{"policy_skip", "policy_fail", "policy_after_skip"} {
+		if i := h.Info(n); i.UpFailTimes != 1 {
+			t.Fatalf("expect upstream failure counted, got %+v", i)
+		}
+	}
+}
+
+func TestSetDepends(t *testing.T) {
+	crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, nil, "dep_a", "dep_b", "dep_c")
+
+	if err := cron.SetDepends("dep_b", []string{"dep_a"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.SetDepends("dep_c", []string{"dep_b"}, cron.PolicyRun); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.SetDepends("dep_a", []string{"dep_c"}, ""); err != cron.ErrDependCycle {
+		t.Fatalf("expect ErrDependCycle, got %v", err)
+	}
+	if err := cron.SetDepends("dep_a", []string{"dep_a"}, ""); err != cron.ErrDependOnItself {
+		t.Fatalf("expect ErrDependOnItself, got %v", err)
+	}
+	if err := cron.SetDepends("dep_a", []string{"dep_b"}, "bad"); err != cron.ErrPolicy {
+		t.Fatalf("expect ErrPolicy, got %v", err)
+	}
+	if err := cron.SetDepends("dep_a", []string{"missing"}, ""); err != cron.ErrTaskNotFound {
+		t.Fatalf("expect ErrTaskNotFound, got %v", err)
+	}
+	if i := cron.List(); len(i) != 3 || i[0].After != nil || i[2].Policy != cron.PolicyRun {
+		t.Fatalf("unexpected depends %+v", i)
+	}
+}
+
+func TestCheckFlows(t *testing.T) {
+	crontest.New(t, testStart)
+	l := &runLog{}
+	registerFlow(t, l, nil, "check_a", "check_x", "check_d")
+
+	c := cron.Config{
+		{Name: "check_a", RC: "@every 1s"},
+		{Name: "check_x", RC: "@every 1s"},
+		{Name: "check_d", After: []string{"check_a", "check_x"}},
+	}
+	if err := (cron.Cpnt{}).Init(&c); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Start(); !errors.Is(err, cron.ErrFanInNoRoot) {
+		t.Fatalf("expect ErrFanInNoRoot, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package cron
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	watchdogInterval = 10 * time.Second
+)
+
+var (
+	runStore RunStore = NewMemRunStore()
+
+	// 需要记录心跳的运行, run id -> *Task
+	trackedRuns sync.Map
+
+	owner    = hostOwner()
+	ownerTag = fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(owner)))
+
+	// watchdog 所在的调度器, 调度器被替换后重新加入
+	watchdogMu    sync.Mutex
+	watchdogSched Scheduler
+	watchdogEntry cron.EntryID
+)
+
+func hostOwner() string {
+	h, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", h, os.Getpid())
+}
+
+func SetRunStore(s RunStore) {
+	if s == nil {
+		s = NewMemRunStore()
+	}
+	runStore = s
+}
+
+// beginRun 为开启心跳的任务记录运行, normal 模式下跨进程不允许重叠
+func (t *Task) beginRun(id string) bool {
+	t.mu.Lock()
+	timeout := t.heartbeatTimeout
+	t.mu.Unlock()
+	if timeout <= 0 {
+		return true
+	}
+
+	now := clock.Now().Unix()
+	ok, err := runStore.Begin(TaskRun{
+		RunID:       id,
+		Task:        t.name,
+		Owner:       owner,
+		Status:      RunRunning,
+		Timeout:     int64(timeout / time.Second),
+		StartAt:     now,
+		HeartbeatAt: now,
+	}, t.m == ModeNormal)
+	if err != nil {
+		fmt.Println("[cron] Begin Run Failed : ", t.name, id, err)
+		return false
+	}
+	if ok {
+		trackedRuns.Store(id, t)
+	}
+	return ok
+}
+
+func trackDone(id string, r result) {
+	if _, ok := trackedRuns.Load(id); !ok {
+		return
+	}
+	trackedRuns.Delete(id)
+
+	status := RunDone
+	switch r {
+	case resultFailed:
+		status = RunFailed
+	case resultSkipped:
+		status = RunSkipped
+	}
+	if err := runStore.Finish(id, status, clock.Now().Unix()); err != nil {
+		fmt.Println("[cron] Finish Run Failed : ", id, err)
+	}
+}
+
+func heartbeat(id string, percent int, msg string) error {
+	if _, ok := trackedRuns.Load(id); !ok {
+		return nil
+	}
+	return runStore.Heartbeat(id, percent, msg, clock.Now().Unix())
+}
+
+// ReapAbandoned 将心跳超时的运行标记为 abandoned, 由 watchdog 定时调用
+func ReapAbandoned() (int64, error) {
+	return runStore.Abandon(clock.Now().Unix())
+}
+
+// touchRuns 刷新本进程正在执行的运行的心跳, 只有进程退出后运行才会被判定为 abandoned
+func touchRuns() error {
+	now := clock.Now().Unix()
+	var err error
+	trackedRuns.Range(func(k, v interface{}) bool {
+		if e := runStore.Heartbeat(k.(string), -1, "", now); e != nil {
+			err = e
+		}
+		return true
+	})
+	return err
+}
+
+func watchdog() {
+	if err := touchRuns(); err != nil {
+		fmt.Println("[cron] Touch Runs Failed : ", err)
+	}
+	if n, err := ReapAbandoned(); err != nil {
+		fmt.Println("[cron] Reap Abandoned Runs Failed : ", err)
+	} else if n > 0 {
+		fmt.Println("[cron] Abandoned Runs : ", n)
+	}
+	if err := touchJobs(); err != nil {
+		fmt.Println("[cron] Touch Jobs Failed : ", err)
+	}
+	if err := LoadJobs(); err != nil {
+		fmt.Println("[cron] Load Jobs Failed : ", err)
+	}
+}
+
+// startWatchdog 将 watchdog 加入当前调度器, 按调度器的时钟每 watchdogInterval 执行一次
+func startWatchdog() {
+	watchdogMu.Lock()
+	defer watchdogMu.Unlock()
+
+	if watchdogSched == cEnginer {
+		return
+	}
+	if watchdogSched != nil {
+		watchdogSched.Remove(watchdogEntry)
+	}
+	watchdogSched = cEnginer
+	watchdogEntry = cEnginer.Schedule(cron.Every(watchdogInterval), cron.FuncJob(watchdog))
+}
+
+func stopWatchdog() {
+	watchdogMu.Lock()
+	defer watchdogMu.Unlock()
+
+	if watchdogSched != nil {
+		watchdogSched.Remove(watchdogEntry)
+		watchdogSched = nil
+	}
+}
+
+// Runs 返回所有正在运行且开启心跳的运行及其进度
+func Runs() ([]TaskRun, error) {
+	return runStore.Running()
+}
@@ -0,0 +1,236 @@
+package cron
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/joetang09/goengineer/db"
+)
+
+const (
+	JobPending = iota
+	JobRunning
+	JobDone
+	JobFailed
+	JobCanceled
+	JobSkipped
+)
+
+const (
+	jobTableName = "cron_delayed_job"
+)
+
+var (
+	ErrDBGetterNotFound = errors.New("dbGetter not found")
+)
+
+type JobStore interface {
+	Save(DelayedJob) error
+	// Claim 将等待中的任务标记为执行中, 返回 false 时已被其他进程领取
+	Claim(string, int64) (bool, error)
+	// Finish 结束执行中的任务, status 为 JobPending 时放回等待执行
+	Finish(string, int) error
+	Cancel(string) (bool, error)
+	Pending() ([]DelayedJob, error)
+	// Touch 刷新执行中任务的 UpdateTime, 表示执行的进程仍然存活
+	Touch([]string, int64) error
+	// Requeue 将 UpdateTime 早于 before 的执行中任务放回等待执行, 用于进程崩溃后恢复
+	Requeue(int64) (int64, error)
+}
+
+type DelayedJob struct {
+	ID         string `gorm:"column:id;type:varchar(64);primary_key;not null;default:''"`
+	Handler    string `gorm:"column:handler;type:varchar(255);not null;default:''"`
+	Args       string `gorm:"column:args;type:text;not null"`
+	RunAt      int64  `gorm:"column:run_at;type:bigint(20);not null;default:0;index"`
+	Status     int    `gorm:"column:status;type:tinyint(4);not null;default:0"`
+	CreateTime int    `gorm:"column:create_time;type:int(10) unsigned;not null;default:0"`
+	UpdateTime int    `gorm:"column:update_time;type:int(10) unsigned;not null;default:0"`
+}
+
+func (DelayedJob) TableName() string {
+	return jobTableName
+}
+
+type memJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]DelayedJob
+}
+
+func NewMemJobStore() *memJobStore {
+	return &memJobStore{jobs: map[string]DelayedJob{}}
+}
+
+func (m *memJobStore) Save(j DelayedJob) error {
+	m.mu.Lock()
+	m.jobs[j.ID] = j
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memJobStore) set(id string, from, to int, now int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok || j.Status != from {
+		return false
+	}
+	j.Status = to
+	j.UpdateTime = int(now)
+	m.jobs[id] = j
+	return true
+}
+
+func (m *memJobStore) Claim(id string, now int64) (bool, error) {
+	return m.set(id, JobPending, JobRunning, now), nil
+}
+
+func (m *memJobStore) Finish(id string, status int) error {
+	if status == JobPending {
+		m.set(id, JobRunning, JobPending, clock.Now().Unix())
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 已结束的任务不再保留
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memJobStore) Cancel(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok || j.Status != JobPending {
+		return false, nil
+	}
+	delete(m.jobs, id)
+	return true, nil
+}
+
+func (m *memJobStore) Pending() ([]DelayedJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := []DelayedJob{}
+	for _, j := range m.jobs {
+		if j.Status == JobPending {
+			r = append(r, j)
+		}
+	}
+	return r, nil
+}
+
+func (m *memJobStore) Touch(ids []string, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		if j, ok := m.jobs[id]; ok && j.Status == JobRunning {
+			j.UpdateTime = int(now)
+			m.jobs[id] = j
+		}
+	}
+	return nil
+}
+
+func (m *memJobStore) Requeue(before int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := int64(0)
+	for id, j := range m.jobs {
+		if j.Status == JobRunning && int64(j.UpdateTime) < before {
+			j.Status = JobPending
+			m.jobs[id] = j
+			n++
+		}
+	}
+	return n, nil
+}
+
+type dbJobStore struct {
+	DBGetter func() *gorm.DB
+}
+
+func NewDBJobStore(f func() *gorm.DB) (*dbJobStore, error) {
+	if f == nil {
+		return nil, ErrDBGetterNotFound
+	}
+	if !f().HasTable(jobTableName) {
+		if err := f().Scopes(db.TableOptions).CreateTable(&DelayedJob{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &dbJobStore{DBGetter: f}, nil
+}
+
+// NewDBJobStoreByName 使用 db 组件中名为 name 的连接
+func NewDBJobStoreByName(name string) (*dbJobStore, error) {
+	if _, err := db.Write(name); err != nil {
+		return nil, err
+	}
+	return NewDBJobStore(func() *gorm.DB {
+		return db.MustWrite(name)
+	})
+}
+
+func (d *dbJobStore) Save(j DelayedJob) error {
+	return d.DBGetter().Create(&j).Error
+}
+
+// set 与 Claim 一样使用 UpdateColumn, update_time 只由 Claim 与 Touch 写入
+func (d *dbJobStore) set(id string, from, to int) (bool, error) {
+	r := d.DBGetter().Model(&DelayedJob{}).Where("id = ? AND status = ?", id, from).UpdateColumn("status", to)
+	if r.Error != nil {
+		return false, r.Error
+	}
+	return r.RowsAffected == 1, nil
+}
+
+// Claim 使用 UpdateColumns, 不被连接上的时间戳回调覆盖 update_time
+func (d *dbJobStore) Claim(id string, now int64) (bool, error) {
+	r := d.DBGetter().Model(&DelayedJob{}).Where("id = ? AND status = ?", id, JobPending).UpdateColumns(map[string]interface{}{
+		"status":      JobRunning,
+		"update_time": now,
+	})
+	if r.Error != nil {
+		return false, r.Error
+	}
+	return r.RowsAffected == 1, nil
+}
+
+func (d *dbJobStore) Finish(id string, status int) error {
+	_, err := d.set(id, JobRunning, status)
+	return err
+}
+
+func (d *dbJobStore) Cancel(id string) (bool, error) {
+	return d.set(id, JobPending, JobCanceled)
+}
+
+func (d *dbJobStore) Pending() ([]DelayedJob, error) {
+	r := []DelayedJob{}
+	if err := d.DBGetter().Where("status = ?", JobPending).Order("run_at").Find(&r).Error; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *dbJobStore) Touch(ids []string, now int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return d.DBGetter().Model(&DelayedJob{}).Where("id IN (?) AND status = ?", ids, JobRunning).UpdateColumn("update_time", now).Error
+}
+
+func (d *dbJobStore) Requeue(before int64) (int64, error) {
+	r := d.DBGetter().Model(&DelayedJob{}).Where("status = ? AND update_time < ?", JobRunning, before).UpdateColumn("status", JobPending)
+	return r.RowsAffected, r.Error
+}
@@ -0,0 +1,131 @@
+package cron
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+
+队列溢出处理
+
+drop          丢弃新的执行 (默认)
+drop-oldest   丢弃队列中最早的执行, 新的执行入队
+log-and-skip  丢弃新的执行并打印日志
+
+*/
+
+const (
+	OverflowDrop       = "drop"
+	OverflowDropOldest = "drop-oldest"
+	OverflowLogSkip    = "log-and-skip"
+)
+
+var (
+	workers   chan struct{}
+	workersMu sync.RWMutex
+
+	executing int64
+
+	ErrOverflow = errors.New("unknown overflow action")
+)
+
+// PoolConfig 所有任务共享的执行限制, 配置在 cron_pool 下
+type PoolConfig struct {
+	MaxWorkers int // max runs executing at the same time across all tasks, 0 is unlimited
+}
+
+type PoolCpnt struct{}
+
+func (PoolCpnt) Init(options ...interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	c, ok := options[0].(*PoolConfig)
+	if !ok {
+		return nil
+	}
+	SetMaxWorkers(c.MaxWorkers)
+	return nil
+}
+
+func (PoolCpnt) CfgKey() string {
+	return "cron_pool"
+}
+
+func (PoolCpnt) CfgType() interface{} {
+	return PoolConfig{}
+}
+
+func (PoolCpnt) CfgUpdate(i interface{}) {
+	switch c := i.(type) {
+	case *PoolConfig:
+		SetMaxWorkers(c.MaxWorkers)
+	case PoolConfig:
+		SetMaxWorkers(c.MaxWorkers)
+	}
+}
+
+type PoolStats struct {
+	Workers   int   `json:"workers"`
+	Executing int64 `json:"executing"`
+	Queued    int   `json:"queued"`
+}
+
+// SetMaxWorkers 限制所有任务同时执行的数量, n <= 0 时不限制
+func SetMaxWorkers(n int) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	if n <= 0 {
+		workers = nil
+		return
+	}
+	workers = make(chan struct{}, n)
+}
+
+// acquireWorker 等待空闲的 worker, stop 关闭时放弃等待并返回 false
+func acquireWorker(stop <-chan struct{}) (chan struct{}, bool) {
+	workersMu.RLock()
+	w := workers
+	workersMu.RUnlock()
+
+	if w != nil {
+		select {
+		case w <- struct{}{}:
+		case <-stop:
+			return nil, false
+		}
+	}
+	atomic.AddInt64(&executing, 1)
+	return w, true
+}
+
+func releaseWorker(w chan struct{}) {
+	atomic.AddInt64(&executing, -1)
+	if w != nil {
+		<-w
+	}
+}
+
+func Stats() PoolStats {
+	workersMu.RLock()
+	s := PoolStats{Workers: cap(workers)}
+	workersMu.RUnlock()
+
+	s.Executing = atomic.LoadInt64(&executing)
+	tasks.Range(func(k, v interface{}) bool {
+		s.Queued += v.(*Task).queued()
+		return true
+	})
+	return s
+}
+
+func checkOverflow(o string) error {
+	switch o {
+	case "", OverflowDrop, OverflowDropOldest, OverflowLogSkip:
+		return nil
+	}
+	return ErrOverflow
+}
@@ -0,0 +1,139 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+)
+
+func startLimitTest(t *testing.T, parallel bool, item cron.CronTaskItem) (*crontest.Harness, *blockingTask) {
+	h := crontest.New(t, testStart)
+	b := newBlockingTask()
+	item.RC = "@every 1s"
+	m := cron.ModeWaiting
+	if parallel {
+		m = cron.ModeParallel
+	}
+	if err := cron.RegisterNamedTask(item.Name, m, b.run, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{item})
+	return h, b
+}
+
+func TestQueueDrop(t *testing.T) {
+	h, b := startLimitTest(t, false, cron.CronTaskItem{Name: "drop", MaxQueue: 2})
+
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+	h.Tick(4 * time.Second)
+	if i := h.Info("drop"); i.Queued != 2 || i.DropTimes != 2 {
+		t.Fatalf("expect 2 queued and 2 dropped, got %+v", i)
+	}
+	close(b.release)
+	h.Wait()
+	if i := h.Info("drop"); i.SuccessTimes != 3 {
+		t.Fatalf("expect 3 success, got %+v", i)
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	h, b := startLimitTest(t, false, cron.CronTaskItem{Name: "drop_oldest", MaxQueue: 1, Overflow: cron.OverflowDropOldest})
+
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+	h.Tick(3 * time.Second)
+	i := h.Info("drop_oldest")
+	if i.Queued != 1 || i.DropTimes != 2 {
+		t.Fatalf("expect 1 queued and 2 dropped, got %+v", i)
+	}
+	close(b.release)
+	h.Wait()
+	// 保留的是最后一次
+	if j := h.Info("drop_oldest"); j.SuccessTimes != 2 || j.LastRunID == i.LastRunID {
+		t.Fatalf("expect latest run kept, got %+v", j)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	h, b := startLimitTest(t, true, cron.CronTaskItem{Name: "conc", MaxConcurrency: 2})
+
+	h.Tick(4 * time.Second)
+	b.waitStarted(t, 2)
+	if i := h.Info("conc"); i.Executing != 2 || i.Queued != 2 {
+		t.Fatalf("expect 2 executing and 2 queued, got %+v", i)
+	}
+	close(b.release)
+	h.Wait()
+	if i := h.Info("conc"); i.SuccessTimes != 4 {
+		t.Fatalf("expect 4 success, got %+v", i)
+	}
+
+	if err := cron.SetTaskLimit("conc", 1, 1, "bad"); err != cron.ErrOverflow {
+		t.Fatalf("expect ErrOverflow, got %v", err)
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	if err := (cron.PoolCpnt{}).Init(&cron.PoolConfig{MaxWorkers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cron.SetMaxWorkers(0) })
+	h, b := startLimitTest(t, true, cron.CronTaskItem{Name: "pool"})
+
+	h.Tick(3 * time.Second)
+	b.waitStarted(t, 1)
+	if s := cron.Stats(); s.Workers != 1 || s.Executing != 1 {
+		t.Fatalf("expect 1 worker executing, got %+v", s)
+	}
+	select {
+	case <-b.started:
+		t.Fatal("expect other runs waiting for a worker")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(b.release)
+	h.Wait()
+	if i := h.Info("pool"); i.SuccessTimes != 3 {
+		t.Fatalf("expect 3 success, got %+v", i)
+	}
+
+	(cron.PoolCpnt{}).CfgUpdate(cron.PoolConfig{MaxWorkers: 0})
+	if s := cron.Stats(); s.Workers != 0 {
+		t.Fatalf("expect unlimited workers, got %+v", s)
+	}
+}
+
+func TestStopReleasesWaitingRuns(t *testing.T) {
+	h := crontest.New(t, testStart)
+	cron.SetMaxWorkers(1)
+	t.Cleanup(func() { cron.SetMaxWorkers(0) })
+
+	b := newBlockingTask()
+	if err := cron.RegisterNamedTask("stop", cron.ModeParallel, b.run, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{{Name: "stop", RC: "@every 1s"}})
+
+	h.Tick(2 * time.Second)
+	b.waitStarted(t, 1)
+	if err := cron.StopTask("stop"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二次运行在等待 worker, 停止后应放弃等待
+	deadline := time.Now().Add(time.Second)
+	for {
+		i := h.Info("stop")
+		if i.Executing == 1 && i.SkipTimes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect waiting run skipped after stop, got %+v", i)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(b.release)
+	h.Wait()
+}
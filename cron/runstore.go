@@ -0,0 +1,256 @@
+package cron
+
+import (
+	"sync"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/joetang09/goengineer/db"
+)
+
+const (
+	RunRunning = iota
+	RunDone
+	RunFailed
+	RunSkipped
+	RunAbandoned
+)
+
+const (
+	runTableName  = "cron_task_run"
+	lockTableName = "cron_task_lock"
+)
+
+type RunStore interface {
+	// Begin 记录一次运行, exclusive 时任务已有未过期的运行则返回 false
+	Begin(TaskRun, bool) (bool, error)
+	Heartbeat(string, int, string, int64) error
+	Finish(string, int, int64) error
+	// Abandon 将心跳超时的运行标记为 abandoned
+	Abandon(int64) (int64, error)
+	Running() ([]TaskRun, error)
+}
+
+type TaskRun struct {
+	RunID       string `gorm:"column:run_id;type:varchar(128);primary_key;not null;default:''" json:"run_id"`
+	Task        string `gorm:"column:task;type:varchar(255);not null;default:'';index" json:"task"`
+	Owner       string `gorm:"column:owner;type:varchar(255);not null;default:''" json:"owner"`
+	Status      int    `gorm:"column:status;type:tinyint(4);not null;default:0" json:"status"`
+	Percent     int    `gorm:"column:percent;type:int(10);not null;default:0" json:"percent"`
+	Message     string `gorm:"column:message;type:varchar(1024);not null;default:''" json:"message"`
+	Timeout     int64  `gorm:"column:timeout;type:bigint(20);not null;default:0" json:"timeout"`
+	StartAt     int64  `gorm:"column:start_at;type:bigint(20);not null;default:0" json:"start_at"`
+	HeartbeatAt int64  `gorm:"column:heartbeat_at;type:bigint(20);not null;default:0" json:"heartbeat_at"`
+	FinishAt    int64  `gorm:"column:finish_at;type:bigint(20);not null;default:0" json:"finish_at"`
+}
+
+func (TaskRun) TableName() string {
+	return runTableName
+}
+
+// TaskLock 每个任务一行, exclusive 的运行通过条件更新领取, 保证跨进程只有一个
+type TaskLock struct {
+	Task        string `gorm:"column:task;type:varchar(255);primary_key;not null;default:''"`
+	RunID       string `gorm:"column:run_id;type:varchar(128);not null;default:''"`
+	Timeout     int64  `gorm:"column:timeout;type:bigint(20);not null;default:0"`
+	HeartbeatAt int64  `gorm:"column:heartbeat_at;type:bigint(20);not null;default:0"`
+}
+
+func (TaskLock) TableName() string {
+	return lockTableName
+}
+
+type memRunStore struct {
+	mu   sync.Mutex
+	runs map[string]TaskRun
+}
+
+func NewMemRunStore() *memRunStore {
+	return &memRunStore{runs: map[string]TaskRun{}}
+}
+
+func (m *memRunStore) Begin(r TaskRun, exclusive bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if exclusive {
+		for _, o := range m.runs {
+			if o.Task == r.Task && o.Status == RunRunning {
+				return false, nil
+			}
+		}
+	}
+	m.runs[r.RunID] = r
+	return true, nil
+}
+
+func (m *memRunStore) Heartbeat(id string, percent int, msg string, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.runs[id]
+	if !ok || r.Status != RunRunning {
+		return nil
+	}
+	if percent >= 0 {
+		r.Percent, r.Message = percent, msg
+	}
+	r.HeartbeatAt = now
+	m.runs[id] = r
+	return nil
+}
+
+func (m *memRunStore) Finish(id string, status int, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 内存中只保留运行中的记录
+	delete(m.runs, id)
+	return nil
+}
+
+func (m *memRunStore) Abandon(now int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := int64(0)
+	for id, r := range m.runs {
+		if r.Status == RunRunning && r.HeartbeatAt+r.Timeout < now {
+			delete(m.runs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memRunStore) Running() ([]TaskRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := []TaskRun{}
+	for _, o := range m.runs {
+		if o.Status == RunRunning {
+			r = append(r, o)
+		}
+	}
+	return r, nil
+}
+
+type dbRunStore struct {
+	DBGetter func() *gorm.DB
+}
+
+func NewDBRunStore(f func() *gorm.DB) (*dbRunStore, error) {
+	if f == nil {
+		return nil, ErrDBGetterNotFound
+	}
+	if !f().HasTable(runTableName) {
+		if err := f().Scopes(db.TableOptions).CreateTable(&TaskRun{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	if !f().HasTable(lockTableName) {
+		if err := f().Scopes(db.TableOptions).CreateTable(&TaskLock{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &dbRunStore{DBGetter: f}, nil
+}
+
+func NewDBRunStoreByName(name string) (*dbRunStore, error) {
+	if _, err := db.Write(name); err != nil {
+		return nil, err
+	}
+	return NewDBRunStore(func() *gorm.DB {
+		return db.MustWrite(name)
+	})
+}
+
+func (d *dbRunStore) Begin(r TaskRun, exclusive bool) (bool, error) {
+	if exclusive {
+		ok, err := d.lock(r)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if err := d.DBGetter().Create(&r).Error; err != nil {
+		if exclusive {
+			d.unlock(d.DBGetter().Where("run_id = ?", r.RunID))
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// lock 领取任务的锁行, 锁空闲或持有的运行心跳超时时条件更新成功
+func (d *dbRunStore) lock(r TaskRun) (bool, error) {
+	n := 0
+	if err := d.DBGetter().Model(&TaskLock{}).Where("task = ?", r.Task).Count(&n).Error; err != nil {
+		return false, err
+	}
+	if n == 0 {
+		// 并发插入时主键冲突, 确认行已存在后忽略
+		if err := d.DBGetter().Create(&TaskLock{Task: r.Task}).Error; err != nil {
+			if e := d.DBGetter().Model(&TaskLock{}).Where("task = ?", r.Task).Count(&n).Error; e != nil || n == 0 {
+				return false, err
+			}
+		}
+	}
+	u := d.DBGetter().Model(&TaskLock{}).Where("task = ? AND (run_id = '' OR heartbeat_at + timeout < ?)", r.Task, r.StartAt).UpdateColumns(map[string]interface{}{
+		"run_id":       r.RunID,
+		"timeout":      r.Timeout,
+		"heartbeat_at": r.HeartbeatAt,
+	})
+	if u.Error != nil {
+		return false, u.Error
+	}
+	return u.RowsAffected == 1, nil
+}
+
+func (d *dbRunStore) unlock(where *gorm.DB) error {
+	return where.Model(&TaskLock{}).UpdateColumn("run_id", "").Error
+}
+
+func (d *dbRunStore) Heartbeat(id string, percent int, msg string, now int64) error {
+	u := map[string]interface{}{"heartbeat_at": now}
+	if percent >= 0 {
+		u["percent"] = percent
+		u["message"] = msg
+	}
+	if err := d.DBGetter().Model(&TaskRun{}).Where("run_id = ? AND status = ?", id, RunRunning).Updates(u).Error; err != nil {
+		return err
+	}
+	return d.DBGetter().Model(&TaskLock{}).Where("run_id = ?", id).UpdateColumn("heartbeat_at", now).Error
+}
+
+func (d *dbRunStore) Finish(id string, status int, now int64) error {
+	if err := d.DBGetter().Model(&TaskRun{}).Where("run_id = ? AND status = ?", id, RunRunning).Updates(map[string]interface{}{
+		"status":    status,
+		"finish_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	return d.unlock(d.DBGetter().Where("run_id = ?", id))
+}
+
+func (d *dbRunStore) Abandon(now int64) (int64, error) {
+	r := d.DBGetter().Model(&TaskRun{}).Where("status = ? AND heartbeat_at + timeout < ?", RunRunning, now).Updates(map[string]interface{}{
+		"status":    RunAbandoned,
+		"finish_at": now,
+	})
+	if r.Error != nil {
+		return 0, r.Error
+	}
+	if err := d.unlock(d.DBGetter().Where("run_id <> '' AND heartbeat_at + timeout < ?", now)); err != nil {
+		return 0, err
+	}
+	return r.RowsAffected, nil
+}
+
+func (d *dbRunStore) Running() ([]TaskRun, error) {
+	r := []TaskRun{}
+	if err := d.DBGetter().Where("status = ?", RunRunning).Order("start_at").Find(&r).Error; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,85 @@
+package cron_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/db"
+	"github.com/joetang09/goengineer/dbtest"
+)
+
+func newRun(id string, now int64) cron.TaskRun {
+	return cron.TaskRun{RunID: id, Task: "export", Status: cron.RunRunning, Timeout: 60, StartAt: now, HeartbeatAt: now}
+}
+
+func TestDBRunStoreExclusive(t *testing.T) {
+	dbtest.Open(t, "cron_run", db.ConnConfig{})
+	s, err := cron.NewDBRunStoreByName("cron_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.Begin(newRun("r1", 100), true); !ok || err != nil {
+		t.Fatalf("expect r1 begin, got %v %v", ok, err)
+	}
+	if ok, err := s.Begin(newRun("r2", 110), true); ok || err != nil {
+		t.Fatalf("expect r2 rejected while r1 running, got %v %v", ok, err)
+	}
+	if ok, _ := s.Begin(newRun("r3", 110), false); !ok {
+		t.Fatal("expect non exclusive run begin")
+	}
+	s.Finish("r3", cron.RunDone, 120)
+
+	if err := s.Finish("r1", cron.RunDone, 120); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Begin(newRun("r4", 130), true); !ok {
+		t.Fatal("expect r4 begin after r1 finished")
+	}
+
+	// 心跳超时的运行被 Abandon 后释放锁
+	s.Heartbeat("r4", 10, "", 150)
+	if n, _ := s.Abandon(200); n != 0 {
+		t.Fatalf("expect r4 alive, got %d abandoned", n)
+	}
+	if n, _ := s.Abandon(300); n != 1 {
+		t.Fatalf("expect r4 abandoned, got %d", n)
+	}
+	if ok, _ := s.Begin(newRun("r5", 300), true); !ok {
+		t.Fatal("expect r5 begin after r4 abandoned")
+	}
+	if rs, _ := s.Running(); len(rs) != 1 || rs[0].RunID != "r5" {
+		t.Fatalf("expect only r5 running, got %+v", rs)
+	}
+}
+
+func TestDBRunStoreConcurrent(t *testing.T) {
+	dbtest.Open(t, "cron_run_concurrent", db.ConnConfig{})
+	s, err := cron.NewDBRunStoreByName("cron_run_concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var began int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := s.Begin(newRun(fmt.Sprintf("c%d", i), 100), true)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				atomic.AddInt32(&began, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if began != 1 {
+		t.Fatalf("expect exactly 1 exclusive run, got %d", began)
+	}
+}
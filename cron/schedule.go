@@ -0,0 +1,209 @@
+package cron
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	dateLayout   = "2006-01-02"
+	annualLayout = "01-02"
+
+	// 排除日历时最多向后查找的次数, 防止全部被排除时死循环
+	maxExcludeLookup = 100000
+)
+
+var (
+	calendars sync.Map
+
+	ErrCalendarNotFound = errors.New("calendar not found")
+)
+
+type Calendar interface {
+	Excluded(time.Time) bool
+}
+
+// DateCalendar 排除列出的日期, 支持 2006-01-02 与每年重复的 01-02 两种格式
+type DateCalendar map[string]struct{}
+
+func NewDateCalendar(dates ...string) (DateCalendar, error) {
+	c := DateCalendar{}
+	for _, d := range dates {
+		d = strings.TrimSpace(d)
+		if _, err := time.Parse(dateLayout, d); err == nil {
+			c[d] = struct{}{}
+			continue
+		}
+		if _, err := time.Parse(annualLayout, d); err != nil {
+			return nil, err
+		}
+		c[d] = struct{}{}
+	}
+	return c, nil
+}
+
+func (c DateCalendar) Excluded(t time.Time) bool {
+	if _, ok := c[t.Format(dateLayout)]; ok {
+		return true
+	}
+	_, ok := c[t.Format(annualLayout)]
+	return ok
+}
+
+type multiCalendar []Calendar
+
+func (m multiCalendar) Excluded(t time.Time) bool {
+	for _, c := range m {
+		if c.Excluded(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func RegisterCalendar(name string, c Calendar) {
+	calendars.Store(name, c)
+}
+
+func getCalendar(name string) (Calendar, error) {
+	c, ok := calendars.Load(name)
+	if !ok {
+		return nil, ErrCalendarNotFound
+	}
+	return c.(Calendar), nil
+}
+
+type scheduleOptions struct {
+	tz       string
+	calendar Calendar
+	jitter   time.Duration
+}
+
+type ScheduleOption struct {
+	o func(*scheduleOptions)
+}
+
+func TZOption(tz string) ScheduleOption {
+	return ScheduleOption{func(o *scheduleOptions) {
+		o.tz = tz
+	}}
+}
+
+func CalendarOption(c ...Calendar) ScheduleOption {
+	return ScheduleOption{func(o *scheduleOptions) {
+		switch len(c) {
+		case 0:
+			o.calendar = nil
+		case 1:
+			o.calendar = c[0]
+		default:
+			o.calendar = multiCalendar(c)
+		}
+	}}
+}
+
+func JitterOption(d time.Duration) ScheduleOption {
+	return ScheduleOption{func(o *scheduleOptions) {
+		o.jitter = d
+	}}
+}
+
+// taskSchedule 在基础调度上叠加时区, 排除日历与随机偏移
+type taskSchedule struct {
+	base     cron.Schedule
+	loc      *time.Location
+	calendar Calendar
+	jitter   time.Duration
+
+	// 上一次返回的时间与其偏移前的时间
+	mu       sync.Mutex
+	next     time.Time
+	baseNext time.Time
+}
+
+// Next 调度器触发后以加了偏移的时间调用, 此时从偏移前的时间继续计算, 避免 @every 每次推迟一个偏移
+func (s *taskSchedule) Next(t time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := time.Time{}
+	if !s.next.IsZero() && !t.Before(s.next) {
+		if b := s.nextBase(s.baseNext); b.After(t) {
+			n = b
+		}
+	}
+	if n.IsZero() {
+		n = s.nextBase(t)
+	}
+	s.baseNext = n
+	if s.jitter > 0 && !n.IsZero() {
+		n = n.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	s.next = n
+	return n
+}
+
+func (s *taskSchedule) nextBase(t time.Time) time.Time {
+	n := s.base.Next(t)
+	if s.calendar != nil {
+		for i := 0; !n.IsZero() && s.calendar.Excluded(n.In(s.loc)); i++ {
+			if i >= maxExcludeLookup {
+				return time.Time{}
+			}
+			n = s.base.Next(n)
+		}
+	}
+	return n
+}
+
+func parseSchedule(rc string, o scheduleOptions) (cron.Schedule, error) {
+	spec := strings.TrimSpace(rc)
+	if o.tz != "" && !strings.HasPrefix(spec, "TZ=") && !strings.HasPrefix(spec, "CRON_TZ=") {
+		spec = "CRON_TZ=" + o.tz + " " + spec
+	}
+	base, err := parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if o.calendar == nil && o.jitter <= 0 {
+		return base, nil
+	}
+
+	loc := time.Local
+	if ss, ok := base.(*cron.SpecSchedule); ok {
+		loc = ss.Location
+	}
+	return &taskSchedule{
+		base:     base,
+		loc:      loc,
+		calendar: o.calendar,
+		jitter:   o.jitter,
+	}, nil
+}
+
+func itemOptions(tc CronTaskItem) ([]ScheduleOption, error) {
+	ops := []ScheduleOption{TZOption(tc.TZ), JitterOption(time.Duration(tc.Jitter) * time.Second)}
+
+	cs := []Calendar{}
+	for _, name := range tc.Calendars {
+		c, err := getCalendar(name)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	if len(tc.Exclude) > 0 {
+		c, err := NewDateCalendar(tc.Exclude...)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	ops = append(ops, CalendarOption(cs...))
+	return ops, nil
+}
@@ -0,0 +1,105 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
+)
+
+func startScheduleTest(t *testing.T, item cron.CronTaskItem) *crontest.Harness {
+	h := crontest.New(t, testStart)
+	if err := cron.RegisterNamedTask(item.Name, cron.ModeNormal, func(cron.Args) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{item})
+	return h
+}
+
+func TestScheduleTZ(t *testing.T) {
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "tz", RC: "0 0 9 * * *", TZ: "Asia/Shanghai"})
+
+	// 09:00 +08:00
+	if next := h.Info("tz").Next; !next.Equal(time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expect next at 01:00 UTC, got %s", next.UTC())
+	}
+}
+
+func TestScheduleExclude(t *testing.T) {
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "exclude", RC: "0 0 0 * * *", Exclude: []string{"2020-01-02", "01-03"}})
+
+	if next := h.Info("exclude").Next; !next.Equal(time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expect next on 01-04, got %s", next)
+	}
+	h.Advance(4 * 24 * time.Hour)
+	if i := h.Info("exclude"); i.SuccessTimes != 2 {
+		t.Fatalf("expect runs on 01-04 and 01-05 only, got %+v", i)
+	}
+}
+
+func TestScheduleExcludeInTZ(t *testing.T) {
+	// 2020-01-02 01:00 +08:00 是 UTC 的 01-01, 排除按任务时区的日期判断
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "exclude_tz", RC: "0 0 1 * * *", TZ: "Asia/Shanghai", Exclude: []string{"2020-01-02"}})
+
+	if next := h.Info("exclude_tz").Next; !next.Equal(time.Date(2020, 1, 2, 17, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expect next at 01-03 01:00 +08:00, got %s", next.UTC())
+	}
+}
+
+func TestScheduleCalendar(t *testing.T) {
+	c, err := cron.NewDateCalendar("2020-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cron.RegisterCalendar("holiday", c)
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "calendar", RC: "0 0 0 * * *", Calendars: []string{"holiday"}})
+
+	if next := h.Info("calendar").Next; !next.Equal(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expect next on 01-03, got %s", next)
+	}
+
+	if _, err := cron.NewDateCalendar("2020-13-01"); err == nil {
+		t.Fatal("expect error on invalid date")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "jitter", RC: "0 * * * * *", Jitter: 10})
+
+	jittered := false
+	for i := 0; i < 20; i++ {
+		next := h.Info("jitter").Next
+		d := next.Sub(next.Truncate(time.Minute))
+		if d < 0 || d >= 10*time.Second {
+			t.Fatalf("expect jitter in [0, 10s), got %s", d)
+		}
+		if d > 0 {
+			jittered = true
+		}
+		h.Advance(time.Minute)
+	}
+	if !jittered {
+		t.Fatal("expect jitter applied")
+	}
+	h.Advance(10 * time.Second)
+	if i := h.Info("jitter"); i.SuccessTimes != 20 {
+		t.Fatalf("expect 20 runs, got %+v", i)
+	}
+}
+
+func TestScheduleJitterNoDrift(t *testing.T) {
+	h := startScheduleTest(t, cron.CronTaskItem{Name: "every", RC: "@every 1m", Jitter: 10})
+
+	// 每次触发都从偏移前的时间计算, 不会逐次推迟
+	for i := 1; i <= 20; i++ {
+		next := h.Info("every").Next
+		if d := next.Sub(testStart.Add(time.Duration(i) * time.Minute)); d < 0 || d >= 10*time.Second {
+			t.Fatalf("expect run %d within jitter of the period, got %s", i, d)
+		}
+		h.Advance(next.Sub(h.Clock.Now()))
+		if n := h.Info("every").SuccessTimes; n != uint64(i) {
+			t.Fatalf("expect %d runs, got %d", i, n)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package cron
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/robfig/cron"
+	"github.com/robfig/cron/v3"
 )
 
 /**
@@ -34,154 +37,571 @@ const (
 	DefaultRC = "* * * * * *"
 )
 
-type mode byte
+var (
+	modeNames = map[mode]string{
+		ModeNormal:     "normal",
+		ModeWaiting:    "waiting",
+		ModeWaitingOne: "waitingone",
+		ModeParallel:   "parallel",
+	}
+)
 
-type Task struct {
-	name   string
-	f      func()
-	m      mode
-	runCfg string
-	inCron bool
+type mode byte
 
-	cronEnginer *cron.Cron
+func (m mode) String() string {
+	if n, ok := modeNames[m]; ok {
+		return n
+	}
+	return "unknown"
+}
 
-	run     bool
-	runChan chan byte
+type Task struct {
+	name    string
+	handler string
+	f       func(Args)
+	args    Args
+	m       mode
+	runCfg  string
+	inCron  bool
+	entryID cron.EntryID
+	paused  bool
+	opts    scheduleOptions
+
+	cronEnginer Scheduler
+
+	run      bool
+	queue    []runReq
+	notify   chan struct{}
+	stopChan chan struct{}
+
+	maxConcurrency int
+	maxQueue       int
+	overflow       string
+	executing      int
+
+	heartbeatTimeout time.Duration
 
 	mu sync.Mutex
 
 	successTimes uint64
 	panicTimes   uint64
+	skipTimes    uint64
 	runNum       uint64
+	upFailTimes  uint64
+	dropTimes    uint64
+	lastRun      time.Time
+	lastRunID    string
+}
+
+type runReq struct {
+	b    byte
+	id   string
+	args Args
+}
+
+type TaskInfo struct {
+	Name         string    `json:"name"`
+	Handler      string    `json:"handler"`
+	Args         Args      `json:"args"`
+	Mode         string    `json:"mode"`
+	RC           string    `json:"rc"`
+	TZ           string    `json:"tz"`
+	Running      bool      `json:"running"`
+	Paused       bool      `json:"paused"`
+	Next         time.Time `json:"next"`
+	Prev         time.Time `json:"prev"`
+	LastRun      time.Time `json:"last_run"`
+	RunNum       uint64    `json:"run_num"`
+	SuccessTimes uint64    `json:"success_times"`
+	PanicTimes   uint64    `json:"panic_times"`
+	SkipTimes    uint64    `json:"skip_times"`
+	UpFailTimes  uint64    `json:"up_fail_times"`
+	DropTimes    uint64    `json:"drop_times"`
+	Queued       int       `json:"queued"`
+	Executing    int       `json:"executing"`
+	MaxConc      int       `json:"max_concurrency"`
+	MaxQueue     int       `json:"max_queue"`
+	Overflow     string    `json:"overflow"`
+	Heartbeat    int64     `json:"heartbeat_timeout"`
+	LastRunID    string    `json:"last_run_id"`
+	After        []string  `json:"after"`
+	Policy       string    `json:"policy"`
 }
 
-func newTask(name string, m mode, f func(), ce *cron.Cron) *Task {
+func newTask(name string, m mode, f func(Args), args Args, ce Scheduler) *Task {
 	t := Task{
 		name:        name,
 		f:           f,
+		args:        args,
 		m:           m,
 		runCfg:      DefaultRC,
 		run:         false,
 		cronEnginer: ce,
 	}
-	t.runChan = make(chan byte)
+	t.notify = make(chan struct{}, 1)
 	return &t
 }
 
-func (t *Task) listen() {
+func (t *Task) listen(stop chan struct{}) {
 
 	for {
-		if !t.run {
+		select {
+		case <-t.notify:
+		case <-stop:
 			return
 		}
-		select {
-		case rc := <-t.runChan:
 
-			switch rc {
+		for {
+			rc, ok := t.next()
+			if !ok {
+				break
+			}
+			switch rc.b {
 			case runRoutine:
-				go t.runFP()
+				go t.runFP(rc)
 			case runNoRoutine:
-				t.runFP()
+				t.runFP(rc)
 			}
 		}
 	}
 
 }
 
+func (t *Task) next() (runReq, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.run || len(t.queue) == 0 {
+		return runReq{}, false
+	}
+	rc := t.queue[0]
+	if rc.b == runRoutine && t.maxConcurrency > 0 && t.executing >= t.maxConcurrency {
+		return runReq{}, false
+	}
+	t.queue = t.queue[1:]
+	t.executing++
+	return rc, true
+}
+
+func (t *Task) wake() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Task) idle() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue) == 0 && t.executing == 0
+}
+
+func (t *Task) setScheduler(s Scheduler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.inCron {
+		t.cronEnginer = s
+		return
+	}
+	t.unschedule()
+	t.cronEnginer = s
+	if err := t.schedule(t.runCfg); err != nil {
+		fmt.Println("[cron] Move Task Failed : ", t.name, err)
+	}
+}
+
+func (t *Task) queued() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue)
+}
+
+func (t *Task) SetLimit(maxConcurrency, maxQueue int, overflow string) error {
+	if err := checkOverflow(overflow); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.maxConcurrency = maxConcurrency
+	t.maxQueue = maxQueue
+	t.overflow = overflow
+	t.mu.Unlock()
+	t.wake()
+	return nil
+}
+
+// SetHeartbeat 开启运行记录, 超过 timeout 没有心跳的运行会被标记为 abandoned
+func (t *Task) SetHeartbeat(timeout time.Duration) {
+	t.mu.Lock()
+	t.heartbeatTimeout = timeout
+	t.mu.Unlock()
+}
+
+func (t *Task) schedule(rc string) error {
+	s, err := parseSchedule(rc, t.opts)
+	if err != nil {
+		return err
+	}
+	t.entryID = t.cronEnginer.Schedule(s, t)
+	t.runCfg = rc
+	t.inCron = true
+	return nil
+}
+
+func (t *Task) unschedule() {
+	if !t.inCron {
+		return
+	}
+	t.cronEnginer.Remove(t.entryID)
+	t.inCron = false
+}
+
 func (t *Task) Start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.run {
 		return nil
 	}
 
+	// 下游任务与延时任务只由上游或到期的延时任务触发, 不加入 cron
+	if isDownstream(t.name) || strings.HasPrefix(t.name, delayTaskPrefix) {
+		t.unschedule()
+		t.begin()
+		return nil
+	}
 	if !t.inCron {
-		if err := t.cronEnginer.AddJob(t.runCfg, t); err != nil {
+		if err := t.schedule(t.runCfg); err != nil {
 			return err
 		}
 	}
-	t.run = true
-	t.inCron = true
-
-	go t.listen()
+	t.begin()
 	return nil
 }
 
 func (t *Task) StartWithRC(rc string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if isDownstream(t.name) {
+		return ErrDownstreamTask
+	}
 	if t.run {
 		return errors.New("task is running")
 	}
 
-	if t.inCron && rc != t.runCfg {
-		return errors.New("task run config could not change")
+	t.unschedule()
+	if err := t.schedule(rc); err != nil {
+		return err
 	}
-	if !t.inCron {
-		t.runCfg = rc
-		if err := t.cronEnginer.AddJob(t.runCfg, t); err != nil {
-			return err
-		}
+	t.begin()
+	return nil
+}
+
+// StartTriggered 启动任务但不加入 cron, 只由上游任务或手动触发
+func (t *Task) StartTriggered() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.run {
+		return
 	}
+	t.unschedule()
+	t.begin()
+}
 
-	t.inCron = true
+func (t *Task) begin() {
 	t.run = true
-	go t.listen()
-	return nil
+	t.stopChan = make(chan struct{})
+	go t.listen(t.stopChan)
 }
 
 func (t *Task) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if !t.run {
 		return
 	}
+	t.unschedule()
 	t.run = false
+	close(t.stopChan)
+
+	dropped := t.queue
+	t.queue = nil
+	t.skipTimes += uint64(len(dropped))
+	go func() {
+		for _, rc := range dropped {
+			runDone(t.name, rc.id, resultSkipped)
+		}
+	}()
 }
 
-func (t *Task) runFP() {
+func (t *Task) Reschedule(rc string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := parseSchedule(rc, t.opts); err != nil {
+		return err
+	}
+	if !t.inCron {
+		t.runCfg = rc
+		return nil
+	}
+	t.unschedule()
+	return t.schedule(rc)
+}
+
+func (t *Task) SetOptions(ops ...ScheduleOption) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o := t.opts
+	for _, op := range ops {
+		op.o(&o)
+	}
+	if _, err := parseSchedule(t.runCfg, o); err != nil {
+		return err
+	}
+	t.opts = o
+	if !t.inCron {
+		return nil
+	}
+	t.unschedule()
+	return t.schedule(t.runCfg)
+}
+
+func (t *Task) Pause() {
+	t.mu.Lock()
+	t.paused = true
+	t.mu.Unlock()
+}
+
+func (t *Task) Resume() {
+	t.mu.Lock()
+	t.paused = false
+	t.mu.Unlock()
+}
+
+func (t *Task) TriggerNow() error {
+	t.mu.Lock()
+	run := t.run
+	t.mu.Unlock()
+	if !run {
+		return ErrTaskNotRunning
+	}
+	t.fire()
+	return nil
+}
+
+func (t *Task) Info() TaskInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := TaskInfo{
+		Name:         t.name,
+		Handler:      t.handler,
+		Args:         t.args.clone(),
+		Mode:         t.m.String(),
+		RC:           t.runCfg,
+		TZ:           t.opts.tz,
+		Running:      t.run,
+		Paused:       t.paused,
+		LastRun:      t.lastRun,
+		RunNum:       t.runNum,
+		SuccessTimes: t.successTimes,
+		PanicTimes:   t.panicTimes,
+		SkipTimes:    t.skipTimes,
+		UpFailTimes:  t.upFailTimes,
+		DropTimes:    t.dropTimes,
+		Queued:       len(t.queue),
+		Executing:    t.executing,
+		MaxConc:      t.maxConcurrency,
+		MaxQueue:     t.maxQueue,
+		Overflow:     t.overflow,
+		Heartbeat:    int64(t.heartbeatTimeout / time.Second),
+		LastRunID:    t.lastRunID,
+	}
+	if t.inCron {
+		e := t.cronEnginer.Entry(t.entryID)
+		i.Next = e.Next
+		i.Prev = e.Prev
+	}
+	i.After, i.Policy = dependsOf(t.name)
+	return i
+}
+
+func (t *Task) isRunning() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.run
+}
+
+func (t *Task) finished() uint64 {
+	return t.successTimes + t.panicTimes + t.skipTimes + t.dropTimes
+}
+
+func (t *Task) runFP(rc runReq) {
+	id := rc.id
+	defer t.release()
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			t.mu.Lock()
 			t.panicTimes++
 			t.mu.Unlock()
+			runDone(t.name, id, resultFailed)
 		}
 	}()
+	t.mu.Lock()
 	if !t.run {
+		t.skipTimes++
+		t.mu.Unlock()
+		runDone(t.name, id, resultSkipped)
+		return
+	}
+	args := rc.args
+	if args == nil {
+		args = t.args.clone()
+	}
+	stop := t.stopChan
+	t.mu.Unlock()
+
+	// 任务停止后不再等待 worker, 避免 goroutine 一直阻塞
+	w, ok := acquireWorker(stop)
+	if !ok {
+		t.mu.Lock()
+		t.skipTimes++
+		t.mu.Unlock()
+		runDone(t.name, id, resultSkipped)
+		return
+	}
+	defer releaseWorker(w)
+
+	if !t.beginRun(id) {
+		t.mu.Lock()
+		t.skipTimes++
+		t.mu.Unlock()
+		runDone(t.name, id, resultSkipped)
 		return
 	}
 
-	t.f()
+	t.mu.Lock()
+	t.lastRun = clock.Now()
+	t.lastRunID = id
+	t.mu.Unlock()
+
+	if args == nil {
+		args = Args{}
+	}
+	args[runIDKey] = id
+	t.f(args)
 	t.mu.Lock()
 	t.successTimes++
 	t.mu.Unlock()
+	runDone(t.name, id, resultSuccess)
 }
 
-func (t *Task) put(b byte) {
+// release 在下游任务入队之后才减少 executing, 保证 Idle 的判断
+func (t *Task) release() {
+	t.mu.Lock()
+	t.executing--
+	t.mu.Unlock()
+	t.wake()
+}
 
-	go func() {
-		t.mu.Lock()
-		t.runNum++
-		t.mu.Unlock()
-		t.runChan <- b
-	}()
+// runDone 记录一次运行的结果, 用于触发下游任务与更新延时任务状态
+func runDone(name, id string, r result) {
+	trackDone(id, r)
+	flowDone(name, id, r)
+	jobDone(id, r)
+}
+
+// put 需在持有 t.mu 时调用, 队列已满时按 overflow 处理
+func (t *Task) put(b byte, id string, args Args) bool {
+
+	t.runNum++
+	if t.maxQueue > 0 && len(t.queue) >= t.maxQueue {
+		t.dropTimes++
+		if t.overflow != OverflowDropOldest {
+			if t.overflow == OverflowLogSkip {
+				fmt.Println("[cron] Task Queue Full, Skip : ", t.name, id)
+			}
+			return false
+		}
+		oldest := t.queue[0]
+		t.queue = t.queue[1:]
+		go runDone(t.name, oldest.id, resultSkipped)
+	}
+	t.queue = append(t.queue, runReq{b, id, args})
+	t.wake()
+	return true
 }
 
 func (t *Task) Run() {
+	t.mu.Lock()
+	paused := t.paused
+	t.mu.Unlock()
+	if paused {
+		return
+	}
+	t.fire()
+}
+
+// fire 以新的运行 ID 执行任务, 下游任务在同一个运行 ID 下依次触发
+func (t *Task) fire() {
+	id := newRun(t.name)
+	if !t.dispatch(id, nil) {
+		dropRun(id)
+	}
+}
+
+// trigger 由上游任务在同一运行 ID 下触发
+func (t *Task) trigger(id string) bool {
+	t.mu.Lock()
+	ok := t.run && !t.paused
+	t.mu.Unlock()
+	return ok && t.dispatch(id, nil)
+}
+
+func (t *Task) upstreamFailed() {
+	t.mu.Lock()
+	t.upFailTimes++
+	t.mu.Unlock()
+}
+
+func (t *Task) dispatch(id string, args Args) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
 	switch t.m {
 	case ModeNormal:
-		t.mu.Lock()
-		if t.runNum == t.successTimes+t.panicTimes {
-			t.put(runNoRoutine)
+		if t.runNum != t.finished() {
+			return false
 		}
-		t.mu.Unlock()
-
+		return t.put(runNoRoutine, id, args)
 	case ModeWaiting:
-		t.put(runNoRoutine)
+		return t.put(runNoRoutine, id, args)
 	case ModeWaitingOne:
-		t.mu.Lock()
-		if t.runNum < t.successTimes+t.panicTimes+2 {
-			t.put(runNoRoutine)
+		if t.runNum >= t.finished()+2 {
+			return false
 		}
-		t.mu.Unlock()
+		return t.put(runNoRoutine, id, args)
 	case ModeParallel:
-		t.put(runRoutine)
-
+		return t.put(runRoutine, id, args)
 	}
+	return false
+}
 
+// enqueue 不受运行模式与队列长度限制, 用于已经接受的延时任务
+func (t *Task) enqueue(id string, args Args) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := runNoRoutine
+	if t.m == ModeParallel {
+		b = runRoutine
+	}
+	t.runNum++
+	t.queue = append(t.queue, runReq{b, id, args})
+	t.wake()
 }
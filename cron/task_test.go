@@ -1,86 +1,209 @@
-package cron
+package cron_test
 
 import (
-	"fmt"
 	"testing"
 	"time"
+
+	"github.com/joetang09/goengineer/cron"
+	"github.com/joetang09/goengineer/crontest"
 )
 
 var (
-	testF = func() {
-		time.Sleep(time.Microsecond * 1)
-
-		nano := time.Now().UnixNano()
-		if nano%2 == 0 {
-			fmt.Println("panic")
-			panic(1)
-		} else {
-			fmt.Println("running")
+	testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// blockingTask 每次运行都等待 release, 用于模拟上一次执行没有完
+type blockingTask struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingTask() *blockingTask {
+	return &blockingTask{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blockingTask) run(cron.Args) {
+	b.started <- struct{}{}
+	<-b.release
+}
+
+func (b *blockingTask) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-b.started:
+		case <-time.After(time.Second):
+			t.Fatalf("expect %d runs started, got %d", n, i)
 		}
+	}
+}
 
+func runModeTest(t *testing.T, name string, register func(string, func(cron.Args)) error) (*crontest.Harness, *blockingTask) {
+	h := crontest.New(t, testStart)
+	b := newBlockingTask()
+	if err := register(name, b.run); err != nil {
+		t.Fatal(err)
 	}
-)
+	h.Start(cron.Config{{Name: name, RC: "@every 1s"}})
+	return h, b
+}
 
 func TestNormalTask(t *testing.T) {
-	task := newTask("", ModeNormal, testF)
+	h, b := runModeTest(t, "normal", func(n string, f func(cron.Args)) error {
+		return cron.RegisterNamedTask(n, cron.ModeNormal, f, nil)
+	})
+
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+	h.Tick(3 * time.Second)
+	close(b.release)
+	h.Wait()
+
+	i := h.Info("normal")
+	if i.RunNum != 1 || i.SuccessTimes != 1 {
+		t.Fatalf("expect 1 run, got %+v", i)
+	}
 
-	for i := 0; i < 1000; i++ {
-		time.Sleep(time.Microsecond * 50)
+	h.Advance(2 * time.Second)
+	if i := h.Info("normal"); i.SuccessTimes != 3 {
+		t.Fatalf("expect 3 success, got %+v", i)
+	}
+}
 
-		go task.Run()
+func TestWaitingTask(t *testing.T) {
+	h, b := runModeTest(t, "waiting", func(n string, f func(cron.Args)) error {
+		return cron.RegisterNamedTask(n, cron.ModeWaiting, f, nil)
+	})
+
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+	h.Tick(3 * time.Second)
+	close(b.release)
+	h.Wait()
+
+	if i := h.Info("waiting"); i.RunNum != 4 || i.SuccessTimes != 4 {
+		t.Fatalf("expect 4 runs, got %+v", i)
 	}
+}
 
-	time.Sleep(time.Second * 2)
-	if task.runNum != task.successTimes+task.panicTimes {
-		fmt.Println("not equal")
+func TestWaitingOneTask(t *testing.T) {
+	h, b := runModeTest(t, "waitingone", func(n string, f func(cron.Args)) error {
+		return cron.RegisterNamedTask(n, cron.ModeWaitingOne, f, nil)
+	})
+
+	h.Tick(time.Second)
+	b.waitStarted(t, 1)
+	h.Tick(3 * time.Second)
+	close(b.release)
+	h.Wait()
+
+	if i := h.Info("waitingone"); i.RunNum != 2 || i.SuccessTimes != 2 {
+		t.Fatalf("expect 2 runs, got %+v", i)
 	}
-	fmt.Println(task.runNum, task.successTimes, task.panicTimes)
 }
 
-func TestWaitingTask(t *testing.T) {
-	task := newTask("", ModeWaiting, testF)
+func TestParallelTask(t *testing.T) {
+	h, b := runModeTest(t, "parallel", func(n string, f func(cron.Args)) error {
+		return cron.RegisterNamedTask(n, cron.ModeParallel, f, nil)
+	})
 
-	for i := 0; i < 1000; i++ {
-		time.Sleep(time.Microsecond * 50)
+	h.Tick(4 * time.Second)
+	b.waitStarted(t, 4)
+	close(b.release)
+	h.Wait()
 
-		go task.Run()
+	if i := h.Info("parallel"); i.RunNum != 4 || i.SuccessTimes != 4 {
+		t.Fatalf("expect 4 runs, got %+v", i)
 	}
+}
 
-	time.Sleep(time.Second * 3)
-	if task.runNum != 1000 || task.runNum != task.successTimes+task.panicTimes {
-		fmt.Println("not equal")
+func TestPanicTask(t *testing.T) {
+	h := crontest.New(t, testStart)
+	if err := cron.RegisterNamedTask("panic", cron.ModeNormal, func(cron.Args) { panic(1) }, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{{Name: "panic", RC: "@every 1s"}})
+
+	h.Advance(3 * time.Second)
+	if i := h.Info("panic"); i.RunNum != 3 || i.PanicTimes != 3 {
+		t.Fatalf("expect 3 panics, got %+v", i)
 	}
-	fmt.Println(task.runNum, task.successTimes, task.panicTimes)
 }
 
-func TestWaitingOneTask(t *testing.T) {
-	task := newTask("", ModeWaitingOne, testF)
+func TestDelayedJob(t *testing.T) {
+	h := crontest.New(t, testStart)
 
-	for i := 0; i < 1000; i++ {
-		time.Sleep(time.Microsecond * 50)
+	got := []int64{}
+	if err := cron.RegisterHandler("expire", cron.ModeWaiting, func(a cron.Args) {
+		got = append(got, a.GetInt64("order"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h.Start(cron.Config{})
 
-		go task.Run()
+	if _, err := cron.After(10*time.Second, "expire", cron.Args{"order": 1}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := cron.Schedule(testStart.Add(5*time.Second), "expire", cron.Args{"order": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Cancel(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Cancel(id); err != cron.ErrJobNotFound {
+		t.Fatalf("expect ErrJobNotFound, got %v", err)
 	}
 
-	time.Sleep(time.Second * 3)
-	if task.runNum != task.successTimes+task.panicTimes {
-		fmt.Println("not equal")
+	h.Advance(9 * time.Second)
+	if len(got) != 0 {
+		t.Fatalf("expect no run before due, got %v", got)
+	}
+	h.Advance(time.Hour)
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expect order 1 expired once, got %v", got)
 	}
-	fmt.Println(task.runNum, task.successTimes, task.panicTimes)
 }
 
-func TestParallelTask(t *testing.T) {
-	task := newTask("", ModeParallel, testF)
-
-	for i := 0; i < 1000; i++ {
-		time.Sleep(time.Microsecond * 50)
-
-		go task.Run()
+func TestHeartbeatAbandoned(t *testing.T) {
+	h := crontest.New(t, testStart)
+	b := newBlockingTask()
+	progress := make(chan struct{})
+	if err := cron.RegisterNamedTask("export", cron.ModeNormal, func(a cron.Args) {
+		a.Progress(50, "half")
+		close(progress)
+		b.run(a)
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 已退出的进程遗留的运行
+	s := cron.NewMemRunStore()
+	s.Begin(cron.TaskRun{RunID: "dead", Task: "import", Status: cron.RunRunning, Timeout: 60,
+		StartAt: testStart.Unix(), HeartbeatAt: testStart.Unix()}, true)
+	cron.SetRunStore(s)
+	h.Start(cron.Config{{Name: "export", RC: "@every 1m", HeartbeatTimeout: 60}})
+
+	h.Tick(time.Minute)
+	b.waitStarted(t, 1)
+	<-progress
+
+	runs, err := cron.Runs()
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("expect two runs in progress, got %+v %v", runs, err)
 	}
 
-	time.Sleep(time.Second * 3)
-	if task.runNum != task.successTimes+task.panicTimes {
-		fmt.Println("not equal")
+	// 没有调用 Heartbeat 超过 HeartbeatTimeout, 由 watchdog 刷新本进程的运行, 只回收已退出进程的运行
+	h.Tick(2 * time.Minute)
+	runs, err = cron.Runs()
+	if err != nil || len(runs) != 1 || runs[0].Task != "export" || runs[0].Percent != 50 || runs[0].Message != "half" {
+		t.Fatalf("expect only the dead run abandoned, got %+v %v", runs, err)
+	}
+	if n, _ := cron.ReapAbandoned(); n != 0 {
+		t.Fatalf("expect no more abandoned run, got %d", n)
 	}
-	fmt.Println(task.runNum, task.successTimes, task.panicTimes)
+	close(b.release)
+	h.Wait()
 }
@@ -0,0 +1,224 @@
+package crontest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	gcron "github.com/joetang09/goengineer/cron"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Second
+)
+
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+type entry struct {
+	id       cron.EntryID
+	schedule cron.Schedule
+	job      cron.Job
+	next     time.Time
+	prev     time.Time
+}
+
+// Scheduler 按假时钟触发任务, 只在 Advance 时执行
+type Scheduler struct {
+	clock *Clock
+
+	mu      sync.Mutex
+	entries map[cron.EntryID]*entry
+	lastID  cron.EntryID
+}
+
+func NewScheduler(c *Clock) *Scheduler {
+	return &Scheduler{
+		clock:   c,
+		entries: map[cron.EntryID]*entry{},
+	}
+}
+
+func (s *Scheduler) Schedule(schedule cron.Schedule, job cron.Job) cron.EntryID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	s.entries[s.lastID] = &entry{
+		id:       s.lastID,
+		schedule: schedule,
+		job:      job,
+		next:     schedule.Next(s.clock.Now()),
+	}
+	return s.lastID
+}
+
+func (s *Scheduler) Remove(id cron.EntryID) {
+	s.mu.Lock()
+	delete(s.entries, id)
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) Entry(id cron.EntryID) cron.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return cron.Entry{}
+	}
+	return cron.Entry{ID: e.id, Schedule: e.schedule, Next: e.next, Prev: e.prev, Job: e.job}
+}
+
+func (s *Scheduler) Start() {}
+
+func (s *Scheduler) Stop() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// due 返回 until 之前最早需要触发的任务
+func (s *Scheduler) due(until time.Time) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	es := []*entry{}
+	for _, e := range s.entries {
+		if !e.next.IsZero() && !e.next.After(until) {
+			es = append(es, e)
+		}
+	}
+	if len(es) == 0 {
+		return nil
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].next.Equal(es[j].next) {
+			return es[i].id < es[j].id
+		}
+		return es[i].next.Before(es[j].next)
+	})
+	return es[0]
+}
+
+// AdvanceTo 将时钟推进到 t, 按时间顺序触发期间到期的任务, 返回触发次数
+func (s *Scheduler) AdvanceTo(t time.Time) int {
+	return s.advance(t, nil)
+}
+
+func (s *Scheduler) Advance(d time.Duration) int {
+	return s.AdvanceTo(s.clock.Now().Add(d))
+}
+
+// advance 每次触发之后调用 settle
+func (s *Scheduler) advance(t time.Time, settle func()) int {
+	n := 0
+	for {
+		e := s.due(t)
+		if e == nil {
+			break
+		}
+		s.mu.Lock()
+		at := e.next
+		e.prev = at
+		e.next = e.schedule.Next(at)
+		s.mu.Unlock()
+
+		s.clock.set(at)
+		e.job.Run()
+		n++
+		if settle != nil {
+			settle()
+		}
+	}
+	s.clock.set(t)
+	return n
+}
+
+// Harness 将 cron 包切换到假时钟与假调度器, 测试结束时恢复并注销所有任务
+type Harness struct {
+	tb        testing.TB
+	Clock     *Clock
+	Scheduler *Scheduler
+}
+
+func New(tb testing.TB, start time.Time) *Harness {
+	c := NewClock(start)
+	s := NewScheduler(c)
+	h := &Harness{tb: tb, Clock: c, Scheduler: s}
+
+	old := gcron.SetScheduler(s)
+	gcron.SetClock(c)
+	tb.Cleanup(func() {
+		gcron.Reset()
+		gcron.SetScheduler(old)
+		gcron.SetClock(nil)
+	})
+	return h
+}
+
+// Start 按配置启动任务, 与 cron.Cpnt 读取到的配置一致
+func (h *Harness) Start(c gcron.Config) {
+	h.tb.Helper()
+	if err := (gcron.Cpnt{}).Init(&c); err != nil {
+		h.tb.Fatal(err)
+	}
+	if err := gcron.Start(); err != nil {
+		h.tb.Fatal(err)
+	}
+}
+
+// Advance 推进时钟, 每次触发后等待运行全部结束
+func (h *Harness) Advance(d time.Duration) int {
+	h.tb.Helper()
+	return h.Scheduler.advance(h.Clock.Now().Add(d), h.Wait)
+}
+
+// Tick 推进时钟但不等待, 用于模拟长时间运行的任务
+func (h *Harness) Tick(d time.Duration) int {
+	return h.Scheduler.Advance(d)
+}
+
+func (h *Harness) Wait() {
+	h.tb.Helper()
+	deadline := time.Now().Add(defaultWaitTimeout)
+	for !gcron.Idle() {
+		if time.Now().After(deadline) {
+			h.tb.Fatal("crontest: tasks still running after ", defaultWaitTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (h *Harness) Info(name string) gcron.TaskInfo {
+	h.tb.Helper()
+	for _, i := range gcron.List() {
+		if i.Name == name {
+			return i
+		}
+	}
+	h.tb.Fatal("crontest: task not found : ", name)
+	return gcron.TaskInfo{}
+}
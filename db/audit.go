@@ -0,0 +1,323 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+数据变更审计
+
+实现 Audited 的模型, 通过 Cpnt 打开的连接 create/update/delete 时
+在同一事务中写入 db_audit_log, 记录主键, 变更前后的字段 (只包含变化的字段), 操作人与时间
+复合主键按主键字段顺序以 , 连接; 每次操作的审计日志一次批量写入
+update 前后各查询一次受影响的记录, delete 只在之前查询一次
+ConnConfig.Audit 为 true 时 Init 创建审计表, 否则需要自行创建
+
+*/
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+
+	auditTableName = "db_audit_log"
+	auditOldKey    = "db:audit_old"
+)
+
+type Audited interface {
+	Audited()
+}
+
+type AuditLog struct {
+	ID         int64  `gorm:"column:id;primary_key;auto_increment"`
+	Table      string `gorm:"column:table_name;type:varchar(64);not null;default:'';index:idx_audit_entity"`
+	PrimaryKey string `gorm:"column:primary_key;type:varchar(64);not null;default:'';index:idx_audit_entity"`
+	Action     string `gorm:"column:action;type:varchar(16);not null;default:''"`
+	Old        string `gorm:"column:old;type:text"`
+	New        string `gorm:"column:new;type:text"`
+	Actor      string `gorm:"column:actor;type:varchar(128)"`
+	ChangedAt  int64  `gorm:"column:changed_at;type:bigint(20);not null;default:0"`
+}
+
+func (AuditLog) TableName() string {
+	return auditTableName
+}
+
+// Values 返回变更前后的字段
+func (l AuditLog) Values() (old, new map[string]interface{}, err error) {
+	if l.Old != "" {
+		if err = json.Unmarshal([]byte(l.Old), &old); err != nil {
+			return
+		}
+	}
+	if l.New != "" {
+		err = json.Unmarshal([]byte(l.New), &new)
+	}
+	return
+}
+
+func isAudited(scope *gorm.Scope) bool {
+	t := scope.GetModelStruct().ModelType
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := reflect.New(t).Interface().(Audited)
+	return ok
+}
+
+type auditRow struct {
+	pk     string
+	values map[string]interface{}
+}
+
+func auditRows(scope *gorm.Scope, rows interface{}) []auditRow {
+	r := []auditRow{}
+	v := reflect.Indirect(reflect.ValueOf(rows))
+	for i := 0; i < v.Len(); i++ {
+		r = append(r, auditValues(scope.New(v.Index(i).Addr().Interface())))
+	}
+	return r
+}
+
+func auditValues(scope *gorm.Scope) auditRow {
+	m := map[string]interface{}{}
+	for _, f := range scope.Fields() {
+		if f.IsNormal && !f.IsIgnored {
+			m[f.DBName] = f.Field.Interface()
+		}
+	}
+	return auditRow{pk: auditPK(scope), values: m}
+}
+
+// auditPK 复合主键按字段顺序连接
+func auditPK(scope *gorm.Scope) string {
+	fs := scope.PrimaryFields()
+	if len(fs) <= 1 {
+		return fmt.Sprint(scope.PrimaryKeyValue())
+	}
+	r := make([]string, 0, len(fs))
+	for _, f := range fs {
+		r = append(r, fmt.Sprint(f.Field.Interface()))
+	}
+	return strings.Join(r, ",")
+}
+
+// auditTable 去掉 Table("user u") 中的别名
+func auditTable(scope *gorm.Scope) string {
+	if div := strings.Fields(scope.TableName()); len(div) > 0 {
+		return div[0]
+	}
+	return scope.TableName()
+}
+
+func auditLoad(scope *gorm.Scope, db *gorm.DB) ([]auditRow, error) {
+	rows := reflect.New(reflect.SliceOf(scope.GetModelStruct().ModelType))
+	if err := db.Scan(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	return auditRows(scope, rows.Interface()), nil
+}
+
+// auditBefore 在同一连接 (事务) 中按本次操作的条件读取将被修改的记录
+func auditBefore(scope *gorm.Scope) {
+	if scope.HasError() || !isAudited(scope) {
+		return
+	}
+	cp := *scope
+	cp.SQLVars = nil
+	cond := cp.CombinedConditionSql()
+	db := scope.NewDB().Unscoped().Raw(
+		fmt.Sprintf("SELECT %v.* FROM %v %v", softDeleteAlias(scope), scope.QuotedTableName(), cond),
+		cp.SQLVars...,
+	)
+	old, err := auditLoad(scope, db)
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+	scope.InstanceSet(auditOldKey, old)
+}
+
+func auditCreate(scope *gorm.Scope) {
+	if scope.HasError() || !isAudited(scope) {
+		return
+	}
+	row := auditValues(scope)
+	writeAudit(scope, []AuditLog{newAuditLog(scope, AuditCreate, row.pk, nil, row.values)})
+}
+
+// auditReload 按变更前记录的主键重新读取, 表名与列都带上别名
+func auditReload(scope *gorm.Scope, old []auditRow) ([]auditRow, error) {
+	alias := softDeleteAlias(scope)
+	fs := scope.PrimaryFields()
+	conds := make([]string, 0, len(old))
+	vars := make([]interface{}, 0, len(old)*len(fs))
+	for _, o := range old {
+		cs := make([]string, 0, len(fs))
+		for _, f := range fs {
+			cs = append(cs, fmt.Sprintf("%v.%v = ?", alias, scope.Quote(f.DBName)))
+			vars = append(vars, o.values[f.DBName])
+		}
+		conds = append(conds, "("+strings.Join(cs, " AND ")+")")
+	}
+	db := scope.NewDB().Unscoped().Raw(
+		fmt.Sprintf("SELECT %v.* FROM %v WHERE %v", alias, scope.QuotedTableName(), strings.Join(conds, " OR ")),
+		vars...,
+	)
+	return auditLoad(scope, db)
+}
+
+func auditAfter(action string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if scope.HasError() || !isAudited(scope) {
+			return
+		}
+		v, ok := scope.InstanceGet(auditOldKey)
+		if !ok {
+			return
+		}
+		old := v.([]auditRow)
+		if len(old) == 0 {
+			return
+		}
+		logs := make([]AuditLog, 0, len(old))
+		// 删除 (包括软删除) 只记录删除前的字段
+		if action == AuditDelete {
+			for _, o := range old {
+				logs = append(logs, newAuditLog(scope, action, o.pk, o.values, nil))
+			}
+			writeAudit(scope, logs)
+			return
+		}
+		if scope.DB().RowsAffected == 0 {
+			return
+		}
+		cur, err := auditReload(scope, old)
+		if err != nil {
+			scope.Err(err)
+			return
+		}
+		now := map[string]map[string]interface{}{}
+		for _, c := range cur {
+			now[c.pk] = c.values
+		}
+		for _, o := range old {
+			n, ok := now[o.pk]
+			if !ok {
+				continue
+			}
+			if ov, nv := auditDiff(o.values, n); len(nv) > 0 {
+				logs = append(logs, newAuditLog(scope, action, o.pk, ov, nv))
+			}
+		}
+		writeAudit(scope, logs)
+	}
+}
+
+func auditDiff(old, new map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	ov, nv := map[string]interface{}{}, map[string]interface{}{}
+	for k, n := range new {
+		if o := old[k]; !reflect.DeepEqual(o, n) {
+			ov[k], nv[k] = o, n
+		}
+	}
+	return ov, nv
+}
+
+func newAuditLog(scope *gorm.Scope, action, pk string, old, new map[string]interface{}) AuditLog {
+	l := AuditLog{
+		Table:      auditTable(scope),
+		PrimaryKey: pk,
+		Action:     action,
+		ChangedAt:  time.Now().Unix(),
+	}
+	if a, ok := scope.Get(actorKey); ok {
+		l.Actor = fmt.Sprint(a)
+	}
+	if old != nil {
+		b, err := json.Marshal(old)
+		if err != nil {
+			scope.Err(err)
+		}
+		l.Old = string(b)
+	}
+	if new != nil {
+		b, err := json.Marshal(new)
+		if err != nil {
+			scope.Err(err)
+		}
+		l.New = string(b)
+	}
+	return l
+}
+
+func writeAudit(scope *gorm.Scope, logs []AuditLog) {
+	if scope.HasError() || len(logs) == 0 {
+		return
+	}
+	if err := BulkInsert(scope.NewDB(), logs); err != nil {
+		scope.Err(err)
+	}
+}
+
+func registerAudit(c *gorm.Callback) {
+	c.Create().After("gorm:create").Register("my:audit", auditCreate)
+	c.Update().Before("gorm:update").Register("my:audit_load", auditBefore)
+	c.Update().After("gorm:update").Register("my:audit", auditAfter(AuditUpdate))
+	c.Delete().Before("gorm:delete").Register("my:audit_load", auditBefore)
+	c.Delete().After("gorm:delete").Register("my:audit", auditAfter(AuditDelete))
+}
+
+func createAuditTable(db *gorm.DB) error {
+	if db.HasTable(auditTableName) {
+		return nil
+	}
+	return db.CreateTable(&AuditLog{}).Error
+}
+
+// History 返回 value (带主键) 的所有变更记录, 按发生顺序
+func History(db *gorm.DB, value interface{}) ([]AuditLog, error) {
+	scope := db.NewScope(value)
+	r := []AuditLog{}
+	err := db.New().
+		Where("table_name = ? AND primary_key = ?", auditTable(scope), auditPK(scope)).
+		Order("id").
+		Find(&r).Error
+	return r, err
+}
+
+// Replay 依次应用变更记录, 返回每次变更后的记录状态, 删除后为 nil
+func Replay(logs []AuditLog) ([]map[string]interface{}, error) {
+	r := make([]map[string]interface{}, 0, len(logs))
+	var state map[string]interface{}
+	for _, l := range logs {
+		_, n, err := l.Values()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case l.Action == AuditCreate:
+			state = n
+		case n == nil:
+			state = nil
+		default:
+			next := map[string]interface{}{}
+			for k, v := range state {
+				next[k] = v
+			}
+			for k, v := range n {
+				next[k] = v
+			}
+			state = next
+		}
+		r = append(r, state)
+	}
+	return r, nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"testing"
+)
+
+type auditItem struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name"`
+}
+
+func (auditItem) TableName() string {
+	return "audit_item"
+}
+
+func (auditItem) Audited() {}
+
+type auditPair struct {
+	A    int64  `gorm:"column:a;primary_key;auto_increment:false"`
+	B    int64  `gorm:"column:b;primary_key;auto_increment:false"`
+	Name string `gorm:"column:name"`
+}
+
+func (auditPair) TableName() string {
+	return "audit_pair"
+}
+
+func (auditPair) Audited() {}
+
+func TestAudit(t *testing.T) {
+	d := openTables(t, "audit", ConnConfig{Audit: true}, []interface{}{&auditItem{}}).Write()
+
+	i := auditItem{ID: 1, Name: "a"}
+	d.Create(&i)
+	SetActor(d, "alice").Model(&i).Update("name", "b")
+	d.Delete(&i)
+
+	ls, err := History(d, &auditItem{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 3 || ls[0].Action != AuditCreate || ls[1].Action != AuditUpdate || ls[2].Action != AuditDelete {
+		t.Fatalf("expect create, update and delete logs, got %+v", ls)
+	}
+	if ls[1].Actor != "alice" || ls[1].Table != "audit_item" || ls[1].PrimaryKey != "1" {
+		t.Fatalf("expect actor, table and key recorded, got %+v", ls[1])
+	}
+	if old, new, _ := ls[1].Values(); len(new) != 1 || new["name"] != "b" || old["name"] != "a" {
+		t.Fatalf("expect only the changed field, got %v %v", old, new)
+	}
+	states, _ := Replay(ls)
+	if states[1]["name"] != "b" || states[2] != nil {
+		t.Fatalf("expect replayed states, got %v", states)
+	}
+}
+
+func TestAuditBatch(t *testing.T) {
+	d := openTables(t, "audit_batch", ConnConfig{Audit: true}, []interface{}{&auditItem{}}).Write()
+	for id := int64(1); id <= 3; id++ {
+		d.Create(&auditItem{ID: id, Name: "a"})
+	}
+
+	if err := d.Model(&auditItem{}).Where("id IN (?)", []int64{1, 2}).Update("name", "b").Error; err != nil {
+		t.Fatal(err)
+	}
+	ls := []AuditLog{}
+	d.Where("action = ?", AuditUpdate).Order("primary_key").Find(&ls)
+	if len(ls) != 2 || ls[0].PrimaryKey != "1" || ls[1].PrimaryKey != "2" || ls[0].Table != "audit_item" {
+		t.Fatalf("expect a log per updated row, got %+v", ls)
+	}
+
+	// 没有变化的更新不记录
+	d.Model(&auditItem{}).Where("id = ?", 3).Update("name", "a")
+	if n := 0; d.Model(&AuditLog{}).Where("action = ?", AuditUpdate).Count(&n).Error != nil || n != 2 {
+		t.Fatal("expect no log for unchanged rows")
+	}
+}
+
+func TestAuditCompositeKey(t *testing.T) {
+	d := openTables(t, "audit_pair", ConnConfig{Audit: true}, []interface{}{&auditPair{}}).Write()
+
+	d.Create(&auditPair{A: 1, B: 1, Name: "a"})
+	p := auditPair{A: 1, B: 2, Name: "a"}
+	d.Create(&p)
+	d.Model(&p).Update("name", "b")
+
+	ls, _ := History(d, &auditPair{A: 1, B: 2})
+	if len(ls) != 2 || ls[0].PrimaryKey != "1,2" || ls[1].Action != AuditUpdate {
+		t.Fatalf("expect logs keyed by both columns, got %+v", ls)
+	}
+	if ls, _ := History(d, &auditPair{A: 1, B: 1}); len(ls) != 1 {
+		t.Fatalf("expect the other row untouched, got %+v", ls)
+	}
+}
+
+func TestAuditAlias(t *testing.T) {
+	d := openTables(t, "audit_alias", ConnConfig{Audit: true}, []interface{}{&auditItem{}}).Write()
+	d.Create(&auditItem{ID: 1, Name: "a"})
+
+	// 表名带别名时按别名读取, 日志记录真实表名
+	scope := d.Table("audit_item i").NewScope(&auditItem{})
+	if tb := auditTable(scope); tb != "audit_item" {
+		t.Fatalf("expect alias stripped, got %s", tb)
+	}
+	rs, err := auditReload(scope, []auditRow{{pk: "1", values: map[string]interface{}{"id": int64(1)}}})
+	if err != nil || len(rs) != 1 || rs[0].values["name"] != "a" {
+		t.Fatalf("expect row reloaded through the alias, got %+v %v", rs, err)
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	BalanceRandom     = "random"
+	BalanceRoundRobin = "round-robin"
+	BalanceWeighted   = "weighted"
+	BalanceLeastConn  = "least-conn"
+)
+
+var (
+	balancers = map[string]func() Balancer{
+		BalanceRandom:     func() Balancer { return randomBalancer{} },
+		BalanceRoundRobin: func() Balancer { return new(roundRobinBalancer) },
+		BalanceWeighted:   func() Balancer { return weightedBalancer{} },
+		BalanceLeastConn:  func() Balancer { return leastConnBalancer{} },
+	}
+	balancersMu sync.RWMutex
+
+	errBalancerNotFound = errors.New("Balancer Not Found")
+)
+
+type Balancer interface {
+	// Pick 从健康的 slave 中选择一个, ss 不为空
+	Pick(ss []*Slave) *Slave
+}
+
+type Slave struct {
+	mu     sync.RWMutex
+	db     *gorm.DB
+	source string
+	weight int
+	down   int32
+}
+
+// DB 未连接时返回 nil
+func (s *Slave) DB() *gorm.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db
+}
+
+func (s *Slave) setDB(d *gorm.DB) {
+	s.mu.Lock()
+	s.db = d
+	s.mu.Unlock()
+}
+
+func (s *Slave) Weight() int {
+	return s.weight
+}
+
+func (s *Slave) Stats() sql.DBStats {
+	if d := s.DB(); d != nil {
+		return d.DB().Stats()
+	}
+	return sql.DBStats{}
+}
+
+func (s *Slave) Healthy() bool {
+	return atomic.LoadInt32(&s.down) == 0
+}
+
+func (s *Slave) setHealthy(ok bool) bool {
+	if ok {
+		return atomic.CompareAndSwapInt32(&s.down, 1, 0)
+	}
+	return atomic.CompareAndSwapInt32(&s.down, 0, 1)
+}
+
+func RegisterBalancer(name string, f func() Balancer) {
+	balancersMu.Lock()
+	balancers[name] = f
+	balancersMu.Unlock()
+}
+
+func newBalancer(name string) (Balancer, error) {
+	if name == "" {
+		name = BalanceRandom
+	}
+	balancersMu.RLock()
+	f, ok := balancers[name]
+	balancersMu.RUnlock()
+	if !ok {
+		return nil, errBalancerNotFound
+	}
+	return f(), nil
+}
+
+// lockedRand math/rand.Rand 不是并发安全的
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(ss []*Slave) *Slave {
+	return ss[rander.Intn(len(ss))]
+}
+
+type roundRobinBalancer struct {
+	n uint64
+}
+
+func (r *roundRobinBalancer) Pick(ss []*Slave) *Slave {
+	return ss[(atomic.AddUint64(&r.n, 1)-1)%uint64(len(ss))]
+}
+
+type weightedBalancer struct{}
+
+func (weightedBalancer) Pick(ss []*Slave) *Slave {
+	total := 0
+	for _, s := range ss {
+		total += s.weight
+	}
+	if total <= 0 {
+		return ss[rander.Intn(len(ss))]
+	}
+	n := rander.Intn(total)
+	for _, s := range ss {
+		if n < s.weight {
+			return s
+		}
+		n -= s.weight
+	}
+	return ss[len(ss)-1]
+}
+
+type leastConnBalancer struct{}
+
+func (leastConnBalancer) Pick(ss []*Slave) *Slave {
+	r := ss[0]
+	min := r.Stats().InUse
+	for _, s := range ss[1:] {
+		if n := s.Stats().InUse; n < min {
+			r, min = s, n
+		}
+	}
+	return r
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func testSlaves(weights ...int) []*Slave {
+	ss := make([]*Slave, len(weights))
+	for i, w := range weights {
+		ss[i] = &Slave{weight: w}
+	}
+	return ss
+}
+
+func pickCount(b Balancer, ss []*Slave, n int) map[*Slave]int {
+	r := map[*Slave]int{}
+	for i := 0; i < n; i++ {
+		r[b.Pick(ss)]++
+	}
+	return r
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	ss := testSlaves(1, 1, 1)
+	b, _ := newBalancer(BalanceRoundRobin)
+	for i := 0; i < 6; i++ {
+		if s := b.Pick(ss); s != ss[i%3] {
+			t.Fatalf("expect slave %d at pick %d", i%3, i)
+		}
+	}
+}
+
+func TestRandomBalancer(t *testing.T) {
+	ss := testSlaves(1, 1, 1)
+	b, _ := newBalancer("")
+	if c := pickCount(b, ss, 300); len(c) != 3 {
+		t.Fatalf("expect all slaves picked, got %v", c)
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	ss := testSlaves(1, 3)
+	b, _ := newBalancer(BalanceWeighted)
+	c := pickCount(b, ss, 4000)
+	if c[ss[0]] < 700 || c[ss[0]] > 1300 {
+		t.Fatalf("expect about 1/4 picks on weight 1, got %v", c)
+	}
+}
+
+func TestLeastConnBalancer(t *testing.T) {
+	ss := make([]*Slave, 2)
+	for i := range ss {
+		d, err := gorm.Open(DriverSQLite, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		ss[i] = &Slave{db: d, weight: 1}
+	}
+	conn, err := ss[0].DB().DB().Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b, _ := newBalancer(BalanceLeastConn)
+	if s := b.Pick(ss); s != ss[1] {
+		t.Fatal("expect the slave without connections in use")
+	}
+}
+
+type firstBalancer struct{}
+
+func (firstBalancer) Pick(ss []*Slave) *Slave {
+	return ss[0]
+}
+
+func TestRegisterBalancer(t *testing.T) {
+	if _, err := newBalancer("unknown"); err != errBalancerNotFound {
+		t.Fatalf("expect errBalancerNotFound, got %v", err)
+	}
+	RegisterBalancer("first", func() Balancer { return firstBalancer{} })
+	w := openTest(t, "balance", ConnConfig{Balance: "first", Slave: []SlaveConfig{{Source: ":memory:"}, {Source: ":memory:"}}})
+	if w.Read() != w.Slaves()[0].DB() {
+		t.Fatal("expect custom balancer used")
+	}
+	if err := Register("balance_bad", ConnConfig{Driver: DriverSQLite, Source: ":memory:", Balance: "unknown"}); err != errBalancerNotFound {
+		t.Fatalf("expect errBalancerNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"bytes"
+	"container/list"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+查询缓存
+
+  db.MustReadModel(m).Scopes(db.Cache(time.Minute)).Find(&out)
+
+key 由连接, 表, 结果类型, 查询条件组成; 通过 Cpnt 打开的连接写入同一张表时失效
+直接执行的 SQL (Exec/Raw) 不会触发失效
+事务中的查询不读也不写缓存; WithTx/ExecTrans 中的写入在事务提交之后才失效, 回滚时不失效
+
+*/
+
+const (
+	cacheTTLKey = "db:cache_ttl"
+	cacheTxKey  = "db:cache_tx"
+
+	defaultCacheSize = 10000
+)
+
+var (
+	cacheBackend CacheBackend = NewLRUCache(defaultCacheSize)
+	cacheMu      sync.RWMutex
+
+	cacheHits, cacheMisses, cacheInvalidations uint64
+)
+
+// CacheBackend tag 为 连接名|表名, Invalidate 使该 tag 下所有 key 失效
+type CacheBackend interface {
+	Get(key string) ([]byte, bool)
+	Set(key, tag string, value []byte, ttl time.Duration)
+	Invalidate(tag string)
+}
+
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+}
+
+func SetCacheBackend(b CacheBackend) {
+	cacheMu.Lock()
+	cacheBackend = b
+	cacheMu.Unlock()
+}
+
+func getCacheBackend() CacheBackend {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cacheBackend
+}
+
+func CacheStat() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadUint64(&cacheHits),
+		Misses:        atomic.LoadUint64(&cacheMisses),
+		Invalidations: atomic.LoadUint64(&cacheInvalidations),
+	}
+}
+
+// Cache 开启查询缓存, ttl 为缓存时间
+func Cache(ttl time.Duration) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(cacheTTLKey, ttl)
+	}
+}
+
+func cacheTag(scope *gorm.Scope) string {
+	return scopeCallbackConfig(scope).conn + "|" + scope.TableName()
+}
+
+func cacheKey(scope *gorm.Scope, dest interface{}) string {
+	cp := *scope
+	cp.SQLVars = nil
+	cond := cp.CombinedConditionSql()
+	return fmt.Sprintf("%s|%s|%v|%v|%s|%v", cacheTag(scope), reflect.TypeOf(dest), scope.Search.Unscoped, scope.SelectAttrs(), cond, cp.SQLVars)
+}
+
+func cacheQuery(query func(*gorm.Scope)) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(cacheTTLKey)
+		if _, tx := scope.SQLDB().(*sql.Tx); !ok || tx || scope.HasError() {
+			query(scope)
+			return
+		}
+		ttl := v.(time.Duration)
+		dest := scope.Value
+		if d, ok := scope.Get("gorm:query_destination"); ok {
+			dest = d
+		}
+		b := getCacheBackend()
+		key := cacheKey(scope, dest)
+
+		if data, ok := b.Get(key); ok {
+			rv := reflect.Indirect(reflect.ValueOf(dest))
+			rv.Set(reflect.Zero(rv.Type()))
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dest); err == nil {
+				atomic.AddUint64(&cacheHits, 1)
+				if rv.Kind() == reflect.Slice {
+					scope.DB().RowsAffected = int64(rv.Len())
+				} else {
+					scope.DB().RowsAffected = 1
+				}
+				return
+			}
+		}
+
+		atomic.AddUint64(&cacheMisses, 1)
+		query(scope)
+		if scope.HasError() {
+			return
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(dest); err == nil {
+			b.Set(key, cacheTag(scope), buf.Bytes(), ttl)
+		}
+	}
+}
+
+func cacheInvalidate(scope *gorm.Scope) {
+	if scope.HasError() || scope.GetModelStruct().ModelType == nil {
+		return
+	}
+	invalidateTag(scope.DB(), cacheTag(scope))
+}
+
+// cacheTx 事务中写入的表, 提交后统一失效
+type cacheTx struct {
+	mu   sync.Mutex
+	tags map[string]struct{}
+}
+
+func withCacheTx(tx *gorm.DB) (*gorm.DB, *cacheTx) {
+	t := &cacheTx{tags: map[string]struct{}{}}
+	return tx.Set(cacheTxKey, t), t
+}
+
+func (t *cacheTx) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag := range t.tags {
+		getCacheBackend().Invalidate(tag)
+		atomic.AddUint64(&cacheInvalidations, 1)
+	}
+}
+
+func invalidateTag(db *gorm.DB, tag string) {
+	if v, ok := db.Get(cacheTxKey); ok {
+		t := v.(*cacheTx)
+		t.mu.Lock()
+		t.tags[tag] = struct{}{}
+		t.mu.Unlock()
+		return
+	}
+	getCacheBackend().Invalidate(tag)
+	atomic.AddUint64(&cacheInvalidations, 1)
+}
+
+func registerCache(c *gorm.Callback) {
+	if query := c.Query().Get("gorm:query"); query != nil {
+		c.Query().Replace("gorm:query", cacheQuery(query))
+	}
+	c.Create().After("gorm:commit_or_rollback_transaction").Register("my:cache_invalidate", cacheInvalidate)
+	c.Update().After("gorm:commit_or_rollback_transaction").Register("my:cache_invalidate", cacheInvalidate)
+	c.Delete().After("gorm:commit_or_rollback_transaction").Register("my:cache_invalidate", cacheInvalidate)
+}
+
+type lruEntry struct {
+	key    string
+	tag    string
+	value  []byte
+	expire time.Time
+}
+
+type lruCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+}
+
+// NewLRUCache 进程内缓存, 最多保存 size 条
+func NewLRUCache(size int) CacheBackend {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &lruCache{
+		size:  size,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		tags:  map[string]map[string]struct{}{},
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expire) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *lruCache) Set(key, tag string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, tag: tag, value: value, expire: time.Now().Add(ttl)})
+	if _, ok := c.tags[tag]; !ok {
+		c.tags[tag] = map[string]struct{}{}
+	}
+	c.tags[tag][key] = struct{}{}
+
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lruCache) Invalidate(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.tags[tag] {
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+	}
+	delete(c.tags, tag)
+}
+
+func (c *lruCache) remove(el *list.Element) {
+	e := el.Value.(*lruEntry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	if keys, ok := c.tags[e.tag]; ok {
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.tags, e.tag)
+		}
+	}
+}
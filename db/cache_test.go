@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func cachedName(t *testing.T, d *gorm.DB) string {
+	t.Helper()
+	i := testItem{}
+	if err := d.Scopes(Cache(time.Minute)).Where("id = ?", 1).First(&i).Error; err != nil {
+		t.Fatal(err)
+	}
+	return i.Name
+}
+
+func TestCache(t *testing.T) {
+	d := openItems(t, "cache", ConnConfig{}).Write()
+	d.Create(&testItem{ID: 1, Name: "a"})
+
+	cachedName(t, d)
+	s := CacheStat()
+	if n := cachedName(t, d); n != "a" || CacheStat().Hits != s.Hits+1 {
+		t.Fatalf("expect cache hit, got %s %+v", n, CacheStat())
+	}
+	d.Model(&testItem{ID: 1}).Update("name", "b")
+	if n := cachedName(t, d); n != "b" {
+		t.Fatalf("expect cache invalidated by update, got %s", n)
+	}
+
+	// 直接执行的 SQL 不触发失效
+	d.Exec("UPDATE test_item SET name = 'c'")
+	if n := cachedName(t, d); n != "b" {
+		t.Fatalf("expect cached value after raw sql, got %s", n)
+	}
+}
+
+func TestCacheInTx(t *testing.T) {
+	d := openItems(t, "cache_tx", ConnConfig{}).Write()
+	d.Create(&testItem{ID: 1, Name: "a"})
+	cachedName(t, d)
+
+	s := CacheStat()
+	err := WithTx(context.Background(), "cache_tx", func(ctx context.Context) error {
+		tx := MustWriteContext(ctx, "cache_tx")
+		tx.Model(&testItem{ID: 1}).Update("name", "b")
+		// 事务中不读缓存
+		if n := cachedName(t, tx); n != "b" {
+			t.Fatalf("expect uncommitted value in tx, got %s", n)
+		}
+		if CacheStat().Invalidations != s.Invalidations {
+			t.Fatal("expect invalidation deferred to commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cachedName(t, d); n != "b" {
+		t.Fatalf("expect cache invalidated on commit, got %s", n)
+	}
+
+	// 回滚时缓存保持
+	s = CacheStat()
+	ExecTrans(d, func(tx *gorm.DB) error {
+		return tx.Model(&testItem{ID: 1}).Update("name", "c").Error
+	}, func(*gorm.DB) error {
+		return errTest
+	})
+	if n := cachedName(t, d); n != "b" || CacheStat().Invalidations != s.Invalidations {
+		t.Fatalf("expect no invalidation on rollback, got %s", n)
+	}
+
+	ExecTrans(d, func(tx *gorm.DB) error {
+		return tx.Model(&testItem{ID: 1}).Update("name", "d").Error
+	})
+	if n := cachedName(t, d); n != "d" {
+		t.Fatalf("expect cache invalidated on ExecTrans commit, got %s", n)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", "t1", []byte("a"), time.Minute)
+	c.Set("b", "t2", []byte("b"), time.Minute)
+	c.Get("a")
+	c.Set("c", "t2", []byte("c"), time.Minute)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expect least recently used evicted")
+	}
+	c.Invalidate("t2")
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("expect tag invalidated")
+	}
+	c.Set("e", "t1", []byte("e"), -time.Second)
+	if _, ok := c.Get("e"); ok {
+		t.Fatal("expect expired entry missed")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "a" {
+		t.Fatal("expect entry kept")
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	TimeUnix   = "unix"
+	TimeMillis = "millis"
+	TimeTime   = "time"
+
+	callbackConfigKey = "db:callback_config"
+	actorKey          = "db:actor"
+	contextKey        = "db:context"
+)
+
+type ctxActorKey struct{}
+
+type silentLogger struct{}
+
+func (silentLogger) Print(...interface{}) {}
+
+// callbackConfig 连接上时间戳与操作人字段的配置, 通过 gorm 的 Set 随连接传递
+type callbackConfig struct {
+	conn       string
+	createTime string
+	updateTime string
+	createdBy  string
+	updatedBy  string
+	format     string
+
+	slave    bool
+	slow     time.Duration
+	sampling float64
+	redact   bool
+}
+
+var defaultCallbackConfig = callbackConfig{
+	createTime: "CreateTime",
+	updateTime: "UpdateTime",
+	createdBy:  "CreatedBy",
+	updatedBy:  "UpdatedBy",
+	format:     TimeUnix,
+}
+
+func newCallbackConfig(name string, c ConnConfig) (callbackConfig, error) {
+	r := defaultCallbackConfig
+	r.conn = name
+	if c.CreateTimeField != "" {
+		r.createTime = c.CreateTimeField
+	}
+	if c.UpdateTimeField != "" {
+		r.updateTime = c.UpdateTimeField
+	}
+	if c.CreatedByField != "" {
+		r.createdBy = c.CreatedByField
+	}
+	if c.UpdatedByField != "" {
+		r.updatedBy = c.UpdatedByField
+	}
+	r.slow = time.Duration(c.SlowQuery) * time.Millisecond
+	r.sampling = c.TraceSampling
+	r.redact = c.RedactSQL
+	switch c.TimeFormat {
+	case "":
+	case TimeUnix, TimeMillis, TimeTime:
+		r.format = c.TimeFormat
+	default:
+		return r, errConfig
+	}
+	return r, nil
+}
+
+func (c callbackConfig) now() interface{} {
+	now := time.Now()
+	switch c.format {
+	case TimeMillis:
+		return now.UnixNano() / int64(time.Millisecond)
+	case TimeTime:
+		return now
+	}
+	return now.Unix()
+}
+
+func scopeCallbackConfig(scope *gorm.Scope) callbackConfig {
+	if v, ok := scope.Get(callbackConfigKey); ok {
+		return v.(callbackConfig)
+	}
+	return defaultCallbackConfig
+}
+
+// WithActor 将操作人放入 ctx, 通过 ReadContext/WriteContext/WithTx 取得的连接写入时填充 CreatedBy/UpdatedBy
+func WithActor(ctx context.Context, actor interface{}) context.Context {
+	return context.WithValue(ctx, ctxActorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	a := ctx.Value(ctxActorKey{})
+	return a, a != nil
+}
+
+// SetActor 不使用 context 时直接指定操作人
+func SetActor(db *gorm.DB, actor interface{}) *gorm.DB {
+	return db.Set(actorKey, actor)
+}
+
+// withContext 将 ctx 与其中的操作人带到连接上, 供回调使用
+func withContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if ctx == nil {
+		return db
+	}
+	db = db.Set(contextKey, ctx)
+	if a, ok := ActorFrom(ctx); ok {
+		return SetActor(db, a)
+	}
+	return db
+}
+
+func scopeContext(scope *gorm.Scope) context.Context {
+	if v, ok := scope.Get(contextKey); ok {
+		return v.(context.Context)
+	}
+	return context.Background()
+}
+
+// registerCallback 只注册在 Cpnt 打开的连接上, 不影响进程中其他 gorm 连接
+func registerCallback(db *gorm.DB, c callbackConfig) *gorm.DB {
+	db = db.Set(callbackConfigKey, c)
+
+	// 注册时不打印 gorm 的 info 日志
+	quiet := db.New()
+	quiet.SetLogger(silentLogger{})
+	cb := quiet.Callback()
+	cb.Create().After("gorm:update_time_stamp").Register("my:update_time_stamp", func(scope *gorm.Scope) {
+		if !scope.HasError() {
+			c := scopeCallbackConfig(scope)
+			now := c.now()
+			if ct, ok := scope.FieldByName(c.createTime); ok {
+				ct.Set(now)
+			}
+			if ct, ok := scope.FieldByName(c.updateTime); ok {
+				ct.Set(now)
+			}
+			if a, ok := scope.Get(actorKey); ok {
+				for _, name := range []string{c.createdBy, c.updatedBy} {
+					if f, ok := scope.FieldByName(name); ok {
+						if err := f.Set(a); err != nil {
+							scope.Err(err)
+						}
+					}
+				}
+			}
+		}
+	})
+	cb.Update().After("gorm:update_time_stamp").Register("my:update_time_stamp", func(scope *gorm.Scope) {
+		if _, ok := scope.Get("gorm:update_column"); !ok {
+			c := scopeCallbackConfig(scope)
+			scope.SetColumn(c.updateTime, c.now())
+			if a, ok := scope.Get(actorKey); ok {
+				if _, ok := scope.FieldByName(c.updatedBy); ok {
+					scope.SetColumn(c.updatedBy, a)
+				}
+			}
+		}
+	})
+	registerSoftDelete(cb)
+	registerVersion(cb)
+	registerAudit(cb)
+	registerCache(cb)
+	registerTrace(cb)
+	return db
+}
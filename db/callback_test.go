@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type cbItem struct {
+	ID         int64  `gorm:"column:id;primary_key"`
+	Name       string `gorm:"column:name"`
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+	CreatedBy  string `gorm:"column:created_by"`
+	UpdatedBy  string `gorm:"column:updated_by"`
+}
+
+func (cbItem) TableName() string {
+	return "cb_item"
+}
+
+type cbTimeItem struct {
+	ID      int64     `gorm:"column:id;primary_key"`
+	Created time.Time `gorm:"column:created"`
+	Updated time.Time `gorm:"column:updated"`
+}
+
+func (cbTimeItem) TableName() string {
+	return "cb_time_item"
+}
+
+func TestCallbackTimestamps(t *testing.T) {
+	d := openTables(t, "cb", ConnConfig{}, []interface{}{&cbItem{}}).Write()
+
+	before := time.Now().Unix()
+	i := cbItem{ID: 1}
+	d.Create(&i)
+	if i.CreateTime < before || i.UpdateTime != i.CreateTime {
+		t.Fatalf("expect unix timestamps filled, got %+v", i)
+	}
+
+	d.Model(&i).UpdateColumn("update_time", 1)
+	d.Model(&i).Update("name", "n")
+	d.First(&i)
+	if i.UpdateTime < before {
+		t.Fatalf("expect update time set on update, got %+v", i)
+	}
+	d.Model(&i).UpdateColumns(map[string]interface{}{"name": "c", "update_time": 1})
+	if d.First(&i); i.UpdateTime != 1 {
+		t.Fatalf("expect UpdateColumns to keep update time, got %+v", i)
+	}
+}
+
+func TestCallbackTimeFormat(t *testing.T) {
+	d := openTables(t, "cb_millis", ConnConfig{TimeFormat: TimeMillis}, []interface{}{&cbItem{}}).Write()
+	i := cbItem{ID: 1}
+	d.Create(&i)
+	if i.CreateTime < time.Now().Add(-time.Minute).UnixNano()/int64(time.Millisecond) {
+		t.Fatalf("expect millis timestamp, got %+v", i)
+	}
+
+	d = openTables(t, "cb_time", ConnConfig{TimeFormat: TimeTime, CreateTimeField: "Created", UpdateTimeField: "Updated"}, []interface{}{&cbTimeItem{}}).Write()
+	ti := cbTimeItem{ID: 1}
+	d.Create(&ti)
+	if time.Since(ti.Created) > time.Minute || !ti.Updated.Equal(ti.Created) {
+		t.Fatalf("expect custom time fields filled, got %+v", ti)
+	}
+
+	if err := Register("cb_bad", ConnConfig{Driver: DriverSQLite, Source: ":memory:", TimeFormat: "bad"}); err != errConfig {
+		t.Fatalf("expect errConfig, got %v", err)
+	}
+}
+
+func TestCallbackActor(t *testing.T) {
+	openTables(t, "cb_actor", ConnConfig{}, []interface{}{&cbItem{}})
+	ctx := WithActor(context.Background(), "alice")
+
+	i := cbItem{ID: 1}
+	MustWriteContext(ctx, "cb_actor").Create(&i)
+	if i.CreatedBy != "alice" || i.UpdatedBy != "alice" {
+		t.Fatalf("expect actor filled on create, got %+v", i)
+	}
+	SetActor(MustWrite("cb_actor"), "bob").Model(&i).Update("name", "n")
+	MustWrite("cb_actor").First(&i)
+	if i.CreatedBy != "alice" || i.UpdatedBy != "bob" {
+		t.Fatalf("expect only updated_by changed, got %+v", i)
+	}
+
+	WithTx(ctx, "cb_actor", func(ctx context.Context) error {
+		return MustWriteContext(ctx, "cb_actor").Create(&cbItem{ID: 2}).Error
+	})
+	MustWrite("cb_actor").First(&i, 2)
+	if i.CreatedBy != "alice" {
+		t.Fatalf("expect actor carried into the tx, got %+v", i)
+	}
+}
+
+func TestCallbackScoped(t *testing.T) {
+	openTest(t, "cb_scoped", ConnConfig{})
+
+	// 回调只注册在 Cpnt 打开的连接上
+	d, err := gorm.Open(DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.CreateTable(&cbItem{})
+	i := cbItem{ID: 1}
+	d.Create(&i)
+	if i.CreateTime != 0 {
+		t.Fatalf("expect other connections untouched, got %+v", i)
+	}
+}
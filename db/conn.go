@@ -0,0 +1,249 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/joetang09/goengineer/engineer"
+)
+
+const (
+	defaultReconnectBackoff    = time.Second
+	defaultReconnectMaxBackoff = time.Minute
+)
+
+var (
+	holderMu     sync.RWMutex
+	shutdownOnce sync.Once
+
+	errDBNotReady = errors.New("DB Not Ready")
+)
+
+func newWrapper(name string, config ConnConfig) (*Wrapper, error) {
+	config.Driver = driverName(config.Driver)
+	w := &Wrapper{
+		name:         name,
+		config:       config,
+		driver:       config.Driver,
+		stop:         make(chan struct{}),
+		stickyWindow: time.Duration(config.StickyWindow) * time.Second,
+		retry:        Retry{Attempts: config.TxRetry, Backoff: time.Duration(config.TxRetryBackoff) * time.Millisecond},
+		migrationDir: config.MigrationDir,
+	}
+	var err error
+	if w.balancer, err = newBalancer(config.Balance); err != nil {
+		return nil, err
+	}
+	if w.cc, err = newCallbackConfig(name, config); err != nil {
+		return nil, err
+	}
+	for _, s := range config.Slave {
+		weight := s.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		w.slave = append(w.slave, &Slave{source: s.Source, weight: weight, down: 1})
+	}
+	return w, nil
+}
+
+// boot 按连接策略建立连接: Lazy 在第一次使用时连接, Optional 连接失败时不影响启动, 在后台重连
+// slave 连接失败总是在后台重连
+func (w *Wrapper) boot() error {
+	if !w.config.Lazy {
+		if err := w.open(); err != nil {
+			if !w.config.Optional {
+				w.Close()
+				return err
+			}
+			fmt.Printf("[db] %s connect failed, reconnecting in background : %s\n", w.name, err)
+		}
+	}
+	w.startHealthCheck(w.name, time.Duration(w.config.HealthCheck)*time.Second, time.Duration(w.config.MaxLag)*time.Second)
+	return nil
+}
+
+// open 连接尚未连接的 master 与 slave, 返回 master 的错误
+func (w *Wrapper) open() error {
+	w.connMu.Lock()
+	defer w.connMu.Unlock()
+
+	var err error
+	if w.master() == nil {
+		err = w.openMaster()
+	}
+	for _, s := range w.Slaves() {
+		if s.DB() != nil {
+			continue
+		}
+		if e := w.openSlave(s); e != nil {
+			fmt.Printf("[db] slave of %s connect failed : %s\n", w.name, e)
+		}
+	}
+	if !w.connected() {
+		w.startReconnect()
+	}
+	return err
+}
+
+func (w *Wrapper) openMaster() error {
+	c := w.conf()
+	d, err := gorm.Open(w.driver, c.Source)
+	if err != nil {
+		return err
+	}
+	setPool(d, w.driver, c.Source, c)
+	d = registerCallback(d, w.cc)
+	if c.Audit {
+		if err := createAuditTable(d); err != nil {
+			d.Close()
+			return err
+		}
+	}
+	w.mu.Lock()
+	w.dsn = d
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Wrapper) openSlave(s *Slave) error {
+	d, err := gorm.Open(w.driver, s.source)
+	if err != nil {
+		return err
+	}
+	setPool(d, w.driver, s.source, w.conf())
+	cc := w.cc
+	cc.slave = true
+	s.setDB(registerCallback(d, cc))
+	s.setHealthy(true)
+	return nil
+}
+
+func (w *Wrapper) conf() ConnConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.config
+}
+
+func (w *Wrapper) connected() bool {
+	if w.master() == nil {
+		return false
+	}
+	for _, s := range w.Slaves() {
+		if s.DB() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ready 返回 master 是否可用, Lazy 连接在此时建立
+func (w *Wrapper) ready() error {
+	if w.master() != nil {
+		return nil
+	}
+	if !w.config.Lazy {
+		return errDBNotReady
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *Wrapper) startReconnect() {
+	if !atomic.CompareAndSwapInt32(&w.reconnecting, 0, 1) {
+		return
+	}
+	backoff := time.Duration(w.config.ReconnectBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	max := time.Duration(w.config.ReconnectMaxBackoff) * time.Second
+	if max <= 0 {
+		max = defaultReconnectMaxBackoff
+	}
+	go func() {
+		defer atomic.StoreInt32(&w.reconnecting, 0)
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-w.stop:
+				return
+			}
+			if err := w.open(); err == nil && w.connected() {
+				fmt.Printf("[db] %s reconnected\n", w.name)
+				return
+			}
+			if backoff *= 2; backoff > max {
+				backoff = max
+			}
+		}
+	}()
+}
+
+// Close 停止后台任务并关闭 master 与所有 slave
+func (w *Wrapper) Close() error {
+	w.closeOnce.Do(func() { close(w.stop) })
+
+	var err error
+	if d := w.master(); d != nil {
+		err = d.Close()
+	}
+	for _, s := range w.Slaves() {
+		if d := s.DB(); d != nil {
+			if e := d.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// Close 关闭所有连接, engineer 退出时自动调用
+func Close() {
+	holderMu.Lock()
+	hs := dbHolder
+	dbHolder = map[string]*Wrapper{}
+	holderMu.Unlock()
+
+	for name, w := range hs {
+		if err := w.Close(); err != nil {
+			fmt.Printf("[db] close %s : %s\n", name, err)
+		}
+	}
+}
+
+func closeOnShutdown() {
+	shutdownOnce.Do(func() { engineer.OnShutdown(Close) })
+}
+
+func setHolder(name string, w *Wrapper) {
+	holderMu.Lock()
+	defer holderMu.Unlock()
+	dbHolder[name] = w
+}
+
+func lookup(name string) (*Wrapper, bool) {
+	holderMu.RLock()
+	defer holderMu.RUnlock()
+	w, ok := dbHolder[name]
+	return w, ok
+}
+
+// Names 返回所有连接名
+func Names() []string {
+	holderMu.RLock()
+	r := make([]string, 0, len(dbHolder))
+	for name := range dbHolder {
+		r = append(r, name)
+	}
+	holderMu.RUnlock()
+	sort.Strings(r)
+	return r
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitConnected(t *testing.T, w *Wrapper) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !w.connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("expect reconnected in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnLazy(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "lazy")
+	w := openTest(t, "conn_lazy", ConnConfig{Source: filepath.Join(dir, "test.db"), Lazy: true})
+	if w.master() != nil {
+		t.Fatal("expect no connection before first use")
+	}
+	if _, err := Write("conn_lazy"); err == nil {
+		t.Fatal("expect connect error on first use")
+	}
+
+	os.MkdirAll(dir, 0755)
+	if _, err := Write("conn_lazy"); err != nil || w.master() == nil {
+		t.Fatalf("expect connected on use, got %v", err)
+	}
+}
+
+func TestConnOptional(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "optional")
+	source := filepath.Join(dir, "test.db")
+	if err := Register("conn_required", ConnConfig{Driver: DriverSQLite, Source: source}); err == nil {
+		t.Fatal("expect required connection to fail Register")
+	}
+	if _, ok := lookup("conn_required"); ok {
+		t.Fatal("expect failed connection not registered")
+	}
+
+	w := openTest(t, "conn_optional", ConnConfig{Source: source, Optional: true, ReconnectBackoff: 1})
+	if _, err := Write("conn_optional"); err != errDBNotReady {
+		t.Fatalf("expect errDBNotReady, got %v", err)
+	}
+	os.MkdirAll(dir, 0755)
+	waitConnected(t, w)
+	if _, err := Write("conn_optional"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnSlaveReconnect(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "slave")
+	w := openTest(t, "conn_slave", ConnConfig{ReconnectBackoff: 1, Slave: []SlaveConfig{{Source: filepath.Join(dir, "test.db")}}})
+	s := w.Slaves()[0]
+	if s.DB() != nil || w.Read() != w.Write() {
+		t.Fatal("expect reads on master while the slave is down")
+	}
+
+	os.MkdirAll(dir, 0755)
+	waitConnected(t, w)
+	if !s.Healthy() || w.Read() != s.DB() {
+		t.Fatal("expect reads on the reconnected slave")
+	}
+}
@@ -2,7 +2,8 @@ package db
 
 import (
 	"errors"
-	"math/rand"
+	"fmt"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -11,7 +12,7 @@ import (
 )
 
 var (
-	rander = rand.New(rand.NewSource(time.Now().Unix()))
+	rander = newLockedRand()
 
 	dbHolder = map[string]*Wrapper{}
 
@@ -20,15 +21,51 @@ var (
 	errConfig = errors.New("Config Error")
 )
 
-type Config map[string]struct {
-	Driver          string
-	Source          string
-	ConnMaxLifeTime int // in second
+type SlaveConfig struct {
+	Source string
+	Weight int // used by weighted balance, default 1
+}
+
+type ConnConfig struct {
+	Driver          string // mysql, mssql or sqlite3, sqlite3 needs a blank import of db/sqlite
+	Source          string // for sqlite3 a file path or :memory:, a memory db has one connection so do not use it outside a running tx
+	ConnMaxLifeTime int    // in second
 	MaxIdleConns    int
 	MaxOpenConns    int
-	Slave           []struct{ Source string }
+	Slave           []SlaveConfig
+
+	Balance     string // random, round-robin, weighted or least-conn
+	HealthCheck int    // in second, probe slaves if > 0
+	MaxLag      int    // in second, eject mysql slaves lagging behind
+
+	StickyWindow int // in second, read master after a write through a context, default 5
+
+	TxRetry        int // max attempts of WithTx on deadlock or lock wait timeout
+	TxRetryBackoff int // in millisecond
+
+	Optional            bool // boot without it and reconnect in background
+	Lazy                bool // connect on first use
+	ReconnectBackoff    int  // in second, default 1
+	ReconnectMaxBackoff int  // in second, default 60
+
+	MigrationDir  string // sql migration files, {version}_{name}.up.sql
+	MigrateOnBoot bool   // run pending migrations in Init
+
+	CreateTimeField string // default CreateTime
+	UpdateTimeField string // default UpdateTime
+	TimeFormat      string // unix, millis or time, default unix
+	CreatedByField  string // default CreatedBy, filled with the actor of WithActor
+	UpdatedByField  string // default UpdatedBy
+
+	Audit bool // create the audit table of Audited models in Init
+
+	SlowQuery     int     // in millisecond, log slower queries to the db log category if > 0
+	TraceSampling float64 // 0 to 1, rate of queries passed to the tracer
+	RedactSQL     bool    // log and trace sql without parameter values
 }
 
+type Config map[string]ConnConfig
+
 type Cpnt struct{}
 
 func (Cpnt) Init(options ...interface{}) (err error) {
@@ -44,31 +81,34 @@ func (Cpnt) Init(options ...interface{}) (err error) {
 	}
 
 	for name, config := range *c {
-		w := new(Wrapper)
-		w.dsn, err = gorm.Open(config.Driver, config.Source)
-		if err != nil {
+		var w *Wrapper
+		if w, err = newWrapper(name, config); err != nil {
 			return
 		}
-		w.dsn.DB().SetConnMaxLifetime(time.Duration(config.ConnMaxLifeTime) * time.Second)
-		w.dsn.DB().SetMaxIdleConns(config.MaxIdleConns)
-		w.dsn.DB().SetMaxOpenConns(config.MaxOpenConns)
-		for _, s := range config.Slave {
-			var slave *gorm.DB
-
-			slave, err = gorm.Open(config.Driver, s.Source)
-			if err != nil {
-				return
-			}
-			slave.DB().SetConnMaxLifetime(time.Duration(config.ConnMaxLifeTime) * time.Second)
-			slave.DB().SetMaxIdleConns(config.MaxIdleConns)
-			slave.DB().SetMaxOpenConns(config.MaxOpenConns)
-			w.slave = append(w.slave, slave)
+		if err = w.boot(); err != nil {
+			return
 		}
-
-		dbHolder[name] = w
+		setHolder(name, w)
 	}
+	closeOnShutdown()
 
-	registerCallback()
+	for name, config := range *c {
+		if !config.MigrateOnBoot {
+			continue
+		}
+		var m *Migrator
+		if m, err = NewMigrator(name); err != nil {
+			if err == errDBNotReady && config.Optional {
+				fmt.Printf("[db] %s not ready, skip migrations\n", name)
+				err = nil
+				continue
+			}
+			return
+		}
+		if _, err = m.Up(); err != nil {
+			return
+		}
+	}
 	return nil
 }
 
@@ -80,24 +120,96 @@ func (Cpnt) CfgType() interface{} {
 	return Config{}
 }
 
-func (Cpnt) CfgUpdate(interface{}) {
-
+// CfgUpdate 与当前连接比较后增加, 替换或移除连接
+func (Cpnt) CfgUpdate(i interface{}) {
+	var c Config
+	switch v := i.(type) {
+	case *Config:
+		c = *v
+	case Config:
+		c = v
+	default:
+		return
+	}
+	if err := Update(c); err != nil {
+		fmt.Printf("[db] config update : %s\n", err)
+	}
 }
 
 type Wrapper struct {
-	dsn   *gorm.DB
-	slave []*gorm.DB
+	name   string
+	config ConnConfig
+	cc     callbackConfig
+
+	mu     sync.RWMutex
+	connMu sync.Mutex
+	dsn    *gorm.DB
+	slave  []*Slave
+	pinned *gorm.DB
+
+	driver       string
+	balancer     Balancer
+	stop         chan struct{}
+	closeOnce    sync.Once
+	reconnecting int32
+
+	stickyWindow time.Duration
+	retry        Retry
+	migrationDir string
 }
 
+// Write 未连接时返回 nil, 通过 Write/MustWrite 等获取时已保证可用
 func (db *Wrapper) Write() *gorm.DB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.pinned != nil {
+		return db.pinned
+	}
 	return db.dsn
 }
 
+// master 不受 pinConn 影响的 master 连接
+func (db *Wrapper) master() *gorm.DB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.dsn
+}
+
+func (db *Wrapper) pin() *gorm.DB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.pinned
+}
+
+// Read 所有 slave 都不可用时使用 master, 一次加锁读取固定的连接, slave 与 master
 func (db *Wrapper) Read() *gorm.DB {
-	if len(db.slave) == 0 {
-		return db.Write()
+	db.mu.RLock()
+	p, all, m := db.pinned, db.slave, db.dsn
+	db.mu.RUnlock()
+	if p != nil {
+		return p
 	}
-	return db.slave[rander.Intn(len(db.slave))]
+	ss := healthySlaves(all)
+	if len(ss) == 0 {
+		return m
+	}
+	return db.balancer.Pick(ss).DB()
+}
+
+func healthySlaves(all []*Slave) []*Slave {
+	ss := make([]*Slave, 0, len(all))
+	for _, s := range all {
+		if s.Healthy() {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+func (db *Wrapper) Slaves() []*Slave {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.slave
 }
 
 func Read(name string) (*gorm.DB, error) {
@@ -126,9 +238,9 @@ func MustWrite(name string) *gorm.DB {
 }
 
 func mustGet(name string) *Wrapper {
-	c, ok := dbHolder[name]
-	if !ok {
-		panic(errDBNotFound)
+	c, err := get(name)
+	if err != nil {
+		panic(err)
 	}
 
 	return c
@@ -136,37 +248,23 @@ func mustGet(name string) *Wrapper {
 
 func get(name string) (*Wrapper, error) {
 
-	c, ok := dbHolder[name]
+	c, ok := lookup(name)
 	if !ok {
 		return nil, errDBNotFound
 	}
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
 
-func registerCallback() {
-	gorm.DefaultCallback.Create().After("gorm:update_time_stamp").Register("my:update_time_stamp", func(scope *gorm.Scope) {
-		if !scope.HasError() {
-			now := time.Now().Unix()
-			if ct, ok := scope.FieldByName("CreateTime"); ok {
-				ct.Set(now)
-			}
-			if ct, ok := scope.FieldByName("UpdateTime"); ok {
-				ct.Set(now)
-			}
-		}
-	})
-	gorm.DefaultCallback.Update().After("gorm:update_time_stamp").Register("my:update_time_stamp", func(scope *gorm.Scope) {
-		if _, ok := scope.Get("gorm:update_column"); !ok {
-			scope.SetColumn("UpdateTime", time.Now().Unix())
-		}
-	})
-}
-
+// NotDeletedScope 模型嵌入 SoftDelete 后不再需要
 func NotDeletedScope(db *gorm.DB) *gorm.DB {
 	return db.Where("del_flag = ?", 0)
 }
 
+// NotDeletedScopeWithPrefix 模型嵌入 SoftDelete 后使用 SoftDeleteJoin
 func NotDeletedScopeWithPrefix(prefix ...string) func(*gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
 		for _, v := range prefix {
@@ -176,6 +274,14 @@ func NotDeletedScopeWithPrefix(prefix ...string) func(*gorm.DB) *gorm.DB {
 	}
 }
 
+// TableOptions 建表选项, 只有 mysql 使用 ENGINE=InnoDB
+func TableOptions(d *gorm.DB) *gorm.DB {
+	if d.Dialect().GetName() == "mysql" {
+		return d.Set("gorm:table_options", "ENGINE=InnoDB")
+	}
+	return d
+}
+
 func IsError(e error) error {
 	if e != nil && e != gorm.ErrRecordNotFound {
 		return e
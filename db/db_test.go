@@ -0,0 +1,66 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// openTest 注册 sqlite 内存库连接, 测试结束时通过 Remove 移除
+// 默认值与 dbtest.Setup 相同, db 的测试不能引入 dbtest (循环引用)
+func openTest(t *testing.T, name string, c ConnConfig) *Wrapper {
+	t.Helper()
+	if c.Driver == "" {
+		c.Driver = DriverSQLite
+	}
+	if c.Source == "" {
+		c.Source = ":memory:"
+	}
+	if err := Register(name, c); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := lookup(name)
+	t.Cleanup(func() {
+		// 测试中已经移除时忽略
+		Remove(name)
+	})
+	return w
+}
+
+// openTables 在 openTest 的连接上建表, 按顺序插入 seeds
+func openTables(t *testing.T, name string, c ConnConfig, models []interface{}, seeds ...interface{}) *Wrapper {
+	t.Helper()
+	w := openTest(t, name, c)
+	if err := w.Write().CreateTable(models...).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range seeds {
+		if err := w.Write().Create(s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
+
+type testItem struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name"`
+}
+
+func (testItem) TableName() string {
+	return "test_item"
+}
+
+func openItems(t *testing.T, name string, c ConnConfig) *Wrapper {
+	t.Helper()
+	return openTables(t, name, c, []interface{}{&testItem{}})
+}
+
+func countItems(t *testing.T, d *gorm.DB) int {
+	t.Helper()
+	n := 0
+	if err := d.Model(&testItem{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	secondsBehindMaster = "Seconds_Behind_Master"
+)
+
+// startHealthCheck 定时探测 slave, 不可用或延迟过大的 slave 会被移出, 恢复后重新加入
+// 运行时可能增加 slave, 没有 slave 时也启动
+func (db *Wrapper) startHealthCheck(name string, interval, maxLag time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				db.checkSlaves(name, maxLag)
+			case <-db.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (db *Wrapper) checkSlaves(name string, maxLag time.Duration) {
+	for _, s := range db.Slaves() {
+		d := s.DB()
+		if d == nil {
+			continue
+		}
+		err := d.DB().Ping()
+		if err == nil && maxLag > 0 && db.driver == "mysql" {
+			err = checkSlaveLag(d.DB(), maxLag)
+		}
+		if err != nil {
+			if s.setHealthy(false) {
+				fmt.Printf("[db] slave of %s ejected : %s\n", name, err)
+			}
+			continue
+		}
+		if s.setHealthy(true) {
+			fmt.Printf("[db] slave of %s re-admitted\n", name)
+		}
+	}
+}
+
+func checkSlaveLag(d *sql.DB, maxLag time.Duration) error {
+	rows, err := d.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		// 不是 slave
+		return rows.Err()
+	}
+	vals := make([]sql.RawBytes, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return err
+	}
+	for i, c := range cols {
+		if c != secondsBehindMaster {
+			continue
+		}
+		if vals[i] == nil {
+			return fmt.Errorf("replication is not running")
+		}
+		lag, err := strconv.Atoi(string(vals[i]))
+		if err != nil {
+			return err
+		}
+		if time.Duration(lag)*time.Second > maxLag {
+			return fmt.Errorf("replication lag %ds", lag)
+		}
+	}
+	return nil
+}
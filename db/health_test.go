@@ -0,0 +1,41 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestHealthCheck(t *testing.T) {
+	w := openTest(t, "health", ConnConfig{Slave: []SlaveConfig{{Source: ":memory:"}}})
+	s := w.Slaves()[0]
+	if !s.Healthy() || w.Read() != s.DB() {
+		t.Fatal("expect reads on the healthy slave")
+	}
+
+	// 探测失败的 slave 被移出, 读回到 master
+	s.DB().Close()
+	w.checkSlaves("health", 0)
+	if s.Healthy() || w.Read() != w.Write() {
+		t.Fatal("expect slave ejected and reads on master")
+	}
+
+	d, err := gorm.Open(DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setDB(d)
+	w.checkSlaves("health", 0)
+	if !s.Healthy() || w.Read() != d {
+		t.Fatal("expect slave re-admitted")
+	}
+}
+
+func TestHealthCheckLagSkippedOnSQLite(t *testing.T) {
+	w := openTest(t, "health_lag", ConnConfig{Slave: []SlaveConfig{{Source: ":memory:"}}})
+	// 延迟只在 mysql 上检查
+	w.checkSlaves("health_lag", 1)
+	if !w.Slaves()[0].Healthy() {
+		t.Fatal("expect slave kept healthy")
+	}
+}
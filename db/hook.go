@@ -0,0 +1,24 @@
+package db
+
+import (
+	"sync/atomic"
+
+	"github.com/joetang09/goengineer/internal/dbhook"
+)
+
+func init() {
+	dbhook.Pin = pinConn
+	dbhook.Invalidate = invalidateTables
+}
+
+// invalidateTables 失效 name 连接中表的查询缓存, 用于不经过回调的写入
+func invalidateTables(name string, tables ...string) error {
+	if _, err := get(name); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		getCacheBackend().Invalidate(name + "|" + t)
+		atomic.AddUint64(&cacheInvalidations, 1)
+	}
+	return nil
+}
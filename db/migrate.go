@@ -0,0 +1,430 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+数据库迁移
+
+每个连接的迁移来自 Go 函数 (RegisterMigration) 与 ConnConfig.MigrationDir 中的 SQL 文件
+SQL 文件命名为 {version}_{name}.up.sql 与 {version}_{name}.down.sql, version 按字符串排序
+已执行的迁移记录在 schema_migrations 中, 通过 schema_migrations_lock 保证只有一个进程执行
+持有锁期间定时刷新 locked_at, 只有超过 TTL 没有刷新的锁才会被其他进程清理
+SQL 迁移的 checksum 为 up 文件内容; Go 函数无法取得内容, checksum 只由 version 与 name 计算, 修改函数体不会被标记为 modified
+
+*/
+
+const (
+	migrationTableName     = "schema_migrations"
+	migrationLockTableName = "schema_migrations_lock"
+
+	migrationVersionLayout = "20060102150405"
+
+	migrationLockWait    = 60 * time.Second
+	migrationLockTTL     = 10 * time.Minute
+	migrationLockRefresh = migrationLockTTL / 5
+)
+
+var (
+	migrations   = map[string]map[string]*Migration{}
+	migrationsMu sync.Mutex
+
+	errMigrationLock      = errors.New("Migration Lock Timeout")
+	errMigrationDuplicate = errors.New("Duplicate Migration")
+	errMigrationNoDown    = errors.New("Migration Has No Down")
+)
+
+type Migration struct {
+	Version string
+	Name    string
+	Up      func(*gorm.DB) error
+	Down    func(*gorm.DB) error
+
+	checksum string
+}
+
+type SchemaMigration struct {
+	Version   string `gorm:"column:version;type:varchar(64);primary_key;not null;default:''"`
+	Name      string `gorm:"column:name;type:varchar(255);not null;default:''"`
+	Checksum  string `gorm:"column:checksum;type:varchar(64);not null;default:''"`
+	AppliedAt int64  `gorm:"column:applied_at;type:bigint(20);not null;default:0"`
+}
+
+func (SchemaMigration) TableName() string {
+	return migrationTableName
+}
+
+type schemaMigrationLock struct {
+	ID       int    `gorm:"column:id;primary_key;not null;auto_increment:false"`
+	Owner    string `gorm:"column:owner;type:varchar(255);not null;default:''"`
+	LockedAt int64  `gorm:"column:locked_at;type:bigint(20);not null;default:0"`
+}
+
+func (schemaMigrationLock) TableName() string {
+	return migrationLockTableName
+}
+
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // checksum changed after applied
+	Missing   bool // applied but source not found
+}
+
+func RegisterMigration(conn string, m Migration) error {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if _, ok := migrations[conn]; !ok {
+		migrations[conn] = map[string]*Migration{}
+	}
+	if _, ok := migrations[conn][m.Version]; ok {
+		return errMigrationDuplicate
+	}
+	// 函数迁移只能以 version 与 name 作为 checksum
+	m.checksum = checksum(m.Version + "_" + m.Name)
+	migrations[conn][m.Version] = &m
+	return nil
+}
+
+func checksum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+type Migrator struct {
+	name string
+	dir  string
+	db   *gorm.DB
+
+	owner string
+	stop  chan struct{}
+}
+
+func NewMigrator(name string) (*Migrator, error) {
+	w, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := os.Hostname()
+	return &Migrator{name: name, dir: w.migrationDir, db: w.master(), owner: fmt.Sprintf("%s:%d", h, os.Getpid())}, nil
+}
+
+func (m *Migrator) load() ([]*Migration, error) {
+	r := map[string]*Migration{}
+
+	migrationsMu.Lock()
+	for v, mi := range migrations[m.name] {
+		r[v] = mi
+	}
+	migrationsMu.Unlock()
+
+	if m.dir != "" {
+		files, err := filepath.Glob(filepath.Join(m.dir, "*.up.sql"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			mi, err := loadSQLMigration(f)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := r[mi.Version]; ok {
+				return nil, fmt.Errorf("%w : %s", errMigrationDuplicate, mi.Version)
+			}
+			r[mi.Version] = mi
+		}
+	}
+
+	ms := make([]*Migration, 0, len(r))
+	for _, mi := range r {
+		ms = append(ms, mi)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms, nil
+}
+
+func loadSQLMigration(upFile string) (*Migration, error) {
+	base := strings.TrimSuffix(filepath.Base(upFile), ".up.sql")
+	div := strings.SplitN(base, "_", 2)
+	mi := &Migration{Version: div[0]}
+	if len(div) > 1 {
+		mi.Name = div[1]
+	}
+
+	up, err := ioutil.ReadFile(upFile)
+	if err != nil {
+		return nil, err
+	}
+	mi.checksum = checksum(string(up))
+	mi.Up = sqlRunner(string(up))
+
+	downFile := strings.TrimSuffix(upFile, ".up.sql") + ".down.sql"
+	if down, err := ioutil.ReadFile(downFile); err == nil {
+		mi.Down = sqlRunner(string(down))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return mi, nil
+}
+
+func sqlRunner(content string) func(*gorm.DB) error {
+	return func(db *gorm.DB) error {
+		for _, stmt := range splitSQL(content) {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitSQL 按行尾的 ; 拆分语句, 跳过 -- 注释
+func splitSQL(content string) []string {
+	r := []string{}
+	cur := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "--") {
+			continue
+		}
+		cur = append(cur, line)
+		if strings.HasSuffix(t, ";") {
+			r = append(r, strings.TrimSuffix(strings.TrimSpace(strings.Join(cur, "\n")), ";"))
+			cur = cur[:0]
+		}
+	}
+	if s := strings.TrimSpace(strings.Join(cur, "\n")); s != "" {
+		r = append(r, s)
+	}
+	return r
+}
+
+func (m *Migrator) ensureTables() error {
+	if !m.db.HasTable(migrationTableName) {
+		if err := m.db.CreateTable(&SchemaMigration{}).Error; err != nil {
+			return err
+		}
+	}
+	if !m.db.HasTable(migrationLockTableName) {
+		if err := m.db.CreateTable(&schemaMigrationLock{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) lock() error {
+	deadline := time.Now().Add(migrationLockWait)
+	for {
+		l := schemaMigrationLock{ID: 1, Owner: m.owner, LockedAt: time.Now().Unix()}
+		if err := m.db.Create(&l).Error; err == nil {
+			m.stop = make(chan struct{})
+			go m.keepLock(m.stop)
+			return nil
+		}
+		// 清理异常退出留下的锁
+		m.db.Where("id = ? AND locked_at < ?", 1, time.Now().Add(-migrationLockTTL).Unix()).Delete(&schemaMigrationLock{})
+		if time.Now().After(deadline) {
+			return errMigrationLock
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// keepLock 执行较长的迁移时刷新锁, 避免被其他进程当作过期锁清理
+func (m *Migrator) keepLock(stop chan struct{}) {
+	t := time.NewTicker(migrationLockRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := m.refresh(); err != nil {
+				fmt.Printf("[db] migration lock refresh %s : %s\n", m.name, err)
+			}
+		}
+	}
+}
+
+func (m *Migrator) refresh() error {
+	return m.db.Model(&schemaMigrationLock{}).Where("id = ? AND owner = ?", 1, m.owner).UpdateColumn("locked_at", time.Now().Unix()).Error
+}
+
+func (m *Migrator) unlock() {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	if err := m.db.Where("id = ? AND owner = ?", 1, m.owner).Delete(&schemaMigrationLock{}).Error; err != nil {
+		fmt.Printf("[db] migration unlock %s : %s\n", m.name, err)
+	}
+}
+
+func (m *Migrator) applied() (map[string]SchemaMigration, error) {
+	rs := []SchemaMigration{}
+	if err := m.db.Find(&rs).Error; err != nil {
+		return nil, err
+	}
+	r := map[string]SchemaMigration{}
+	for _, v := range rs {
+		r[v.Version] = v
+	}
+	return r, nil
+}
+
+// prepare 需在 ensureTables 之后调用
+func (m *Migrator) prepare() ([]*Migration, map[string]SchemaMigration, error) {
+	ms, err := m.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	ap, err := m.applied()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ms, ap, nil
+}
+
+// Up 执行所有未执行的迁移, 返回执行的数量
+func (m *Migrator) Up() (int, error) {
+	if err := m.ensureTables(); err != nil {
+		return 0, err
+	}
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	ms, ap, err := m.prepare()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, mi := range ms {
+		if _, ok := ap[mi.Version]; ok {
+			continue
+		}
+		mi := mi
+		err := ExecTrans(m.db, func(db *gorm.DB) error {
+			if err := mi.Up(db); err != nil {
+				return err
+			}
+			return db.Create(&SchemaMigration{
+				Version:   mi.Version,
+				Name:      mi.Name,
+				Checksum:  mi.checksum,
+				AppliedAt: time.Now().Unix(),
+			}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migrate up %s_%s : %w", mi.Version, mi.Name, err)
+		}
+		fmt.Printf("[db] migrated up %s : %s_%s\n", m.name, mi.Version, mi.Name)
+		n++
+	}
+	return n, nil
+}
+
+// Down 回滚最近执行的 steps 个迁移
+func (m *Migrator) Down(steps int) (int, error) {
+	if err := m.ensureTables(); err != nil {
+		return 0, err
+	}
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	ms, ap, err := m.prepare()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := len(ms) - 1; i >= 0 && n < steps; i-- {
+		mi := ms[i]
+		if _, ok := ap[mi.Version]; !ok {
+			continue
+		}
+		if mi.Down == nil {
+			return n, fmt.Errorf("%w : %s_%s", errMigrationNoDown, mi.Version, mi.Name)
+		}
+		err := ExecTrans(m.db, func(db *gorm.DB) error {
+			if err := mi.Down(db); err != nil {
+				return err
+			}
+			return db.Where("version = ?", mi.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migrate down %s_%s : %w", mi.Version, mi.Name, err)
+		}
+		fmt.Printf("[db] migrated down %s : %s_%s\n", m.name, mi.Version, mi.Name)
+		n++
+	}
+	return n, nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.ensureTables(); err != nil {
+		return nil, err
+	}
+	ms, ap, err := m.prepare()
+	if err != nil {
+		return nil, err
+	}
+	r := []MigrationStatus{}
+	for _, mi := range ms {
+		s := MigrationStatus{Version: mi.Version, Name: mi.Name}
+		if a, ok := ap[mi.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = time.Unix(a.AppliedAt, 0)
+			s.Modified = a.Checksum != mi.checksum
+			delete(ap, mi.Version)
+		}
+		r = append(r, s)
+	}
+	for _, a := range ap {
+		r = append(r, MigrationStatus{
+			Version:   a.Version,
+			Name:      a.Name,
+			Applied:   true,
+			AppliedAt: time.Unix(a.AppliedAt, 0),
+			Missing:   true,
+		})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Version < r[j].Version })
+	return r, nil
+}
+
+// CreateMigration 在 dir 中创建新的 SQL 迁移文件, 返回 up 与 down 文件路径
+func CreateMigration(dir, name string) (string, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	base := filepath.Join(dir, time.Now().Format(migrationVersionLayout)+"_"+name)
+	up, down := base+".up.sql", base+".down.sql"
+	if err := ioutil.WriteFile(up, []byte("-- "+name+" up\n"), 0644); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(down, []byte("-- "+name+" down\n"), 0644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/joetang09/goengineer/engineer"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateConn string
+
+	errMigrationDir  = errors.New("Migration Dir Not Config")
+	errMigrationConn = errors.New("Migration Conn Required")
+)
+
+func init() {
+	c := &cobra.Command{
+		Use:   "migrate",
+		Short: "database schema migrations",
+	}
+	c.PersistentFlags().StringVar(&migrateConn, "conn", "", "connection name, default all; create defaults to the only connection with migration_dir")
+
+	c.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "apply all pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return eachMigrator(func(name string, m *Migrator) error {
+				n, err := m.Up()
+				fmt.Printf("%s : %d migrations applied\n", name, n)
+				return err
+			})
+		},
+	}, &cobra.Command{
+		Use:   "down N",
+		Short: "roll back the last N migrations",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			steps := 1
+			if len(args) > 0 {
+				var err error
+				if steps, err = strconv.Atoi(args[0]); err != nil {
+					return err
+				}
+			}
+			return eachMigrator(func(name string, m *Migrator) error {
+				n, err := m.Down(steps)
+				fmt.Printf("%s : %d migrations rolled back\n", name, n)
+				return err
+			})
+		},
+	}, &cobra.Command{
+		Use:   "status",
+		Short: "show migration status",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return eachMigrator(func(name string, m *Migrator) error {
+				ss, err := m.Status()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("[%s]\n", name)
+				for _, s := range ss {
+					state := "pending"
+					if s.Applied {
+						state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+					}
+					if s.Modified {
+						state += " (modified)"
+					}
+					if s.Missing {
+						state += " (missing)"
+					}
+					fmt.Printf("  %s_%s\t%s\n", s.Version, s.Name, state)
+				}
+				return nil
+			})
+		},
+	}, &cobra.Command{
+		Use:   "create NAME",
+		Short: "create sql migration files",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			c, err := migrateConfig()
+			if err != nil {
+				return err
+			}
+			dir, err := createDir(c, migrateConn)
+			if err != nil {
+				return err
+			}
+			up, down, err := CreateMigration(dir, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println("created", up)
+			fmt.Println("created", down)
+			return nil
+		},
+	})
+
+	engineer.RegisterCommand(c)
+}
+
+// migrateConfig 从配置文件读取连接配置, 与 engineer.Use 解析组件配置的方式相同
+func migrateConfig() (Config, error) {
+	c := Config{}
+	if err := mapstructure.WeakDecode(engineer.GetConfig().Get(Cpnt{}.CfgKey()), &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// createDir 创建迁移文件只读取配置中的 MigrationDir, 不使用已打开的连接; 没有指定连接时只能有一个连接配置了迁移目录
+func createDir(c Config, conn string) (string, error) {
+	if conn != "" {
+		cc, ok := c[conn]
+		if !ok {
+			return "", errDBNotFound
+		}
+		if cc.MigrationDir == "" {
+			return "", errMigrationDir
+		}
+		return cc.MigrationDir, nil
+	}
+	dir := ""
+	for _, cc := range c {
+		if cc.MigrationDir == "" || cc.MigrationDir == dir {
+			continue
+		}
+		if dir != "" {
+			return "", errMigrationConn
+		}
+		dir = cc.MigrationDir
+	}
+	if dir == "" {
+		return "", errMigrationDir
+	}
+	return dir, nil
+}
+
+func eachMigrator(f func(string, *Migrator) error) error {
+	names := Names()
+	if migrateConn != "" {
+		names = []string{migrateConn}
+	}
+	for _, name := range names {
+		m, err := NewMigrator(name)
+		if err != nil {
+			return err
+		}
+		if err := f(name, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
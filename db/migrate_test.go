@@ -0,0 +1,130 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestMigrateFunc(t *testing.T) {
+	w := openTest(t, "migrate_func", ConnConfig{})
+	t.Cleanup(func() {
+		migrationsMu.Lock()
+		delete(migrations, "migrate_func")
+		migrationsMu.Unlock()
+	})
+	up := func(d *gorm.DB) error { return d.CreateTable(&testItem{}).Error }
+	down := func(d *gorm.DB) error { return d.DropTable(&testItem{}).Error }
+	if err := RegisterMigration("migrate_func", Migration{Version: "1", Name: "item", Up: up, Down: down}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMigration("migrate_func", Migration{Version: "1", Name: "again", Up: up}); err != errMigrationDuplicate {
+		t.Fatalf("expect errMigrationDuplicate, got %v", err)
+	}
+
+	m, err := NewMigrator("migrate_func")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Up(); n != 1 || err != nil {
+		t.Fatalf("expect 1 applied, got %d %v", n, err)
+	}
+	if n, _ := m.Up(); n != 0 {
+		t.Fatalf("expect nothing pending, got %d", n)
+	}
+	if !w.Write().HasTable(&testItem{}) {
+		t.Fatal("expect table created")
+	}
+	if n, err := m.Down(1); n != 1 || err != nil {
+		t.Fatalf("expect 1 rolled back, got %d %v", n, err)
+	}
+	if w.Write().HasTable(&testItem{}) {
+		t.Fatal("expect table dropped")
+	}
+}
+
+func TestMigrateSQL(t *testing.T) {
+	dir := t.TempDir()
+	up, _, err := CreateMigration(dir, "item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(up, []byte("-- item\nCREATE TABLE test_item (\n  id integer primary key\n);\nINSERT INTO test_item VALUES (1);\n"), 0644)
+	w := openTest(t, "migrate_sql", ConnConfig{MigrationDir: dir})
+
+	m, _ := NewMigrator("migrate_sql")
+	if n, err := m.Up(); n != 1 || err != nil {
+		t.Fatalf("expect 1 applied, got %d %v", n, err)
+	}
+	if n := countItems(t, w.Write()); n != 1 {
+		t.Fatalf("expect both statements run, got %d", n)
+	}
+	if ss, _ := m.Status(); len(ss) != 1 || !ss[0].Applied || ss[0].Modified {
+		t.Fatalf("expect applied, got %+v", ss)
+	}
+
+	ioutil.WriteFile(up, []byte("CREATE TABLE test_item (id integer primary key);"), 0644)
+	if ss, _ := m.Status(); !ss[0].Modified {
+		t.Fatalf("expect modified, got %+v", ss)
+	}
+	os.Remove(up)
+	if ss, _ := m.Status(); len(ss) != 1 || !ss[0].Missing {
+		t.Fatalf("expect missing, got %+v", ss)
+	}
+}
+
+func TestMigrateLock(t *testing.T) {
+	openTest(t, "migrate_lock", ConnConfig{})
+	m, _ := NewMigrator("migrate_lock")
+	m.ensureTables()
+
+	// 超过 TTL 的锁被清理
+	m.db.Create(&schemaMigrationLock{ID: 1, Owner: "crashed", LockedAt: time.Now().Add(-2 * migrationLockTTL).Unix()})
+	if err := m.lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-migrationLockTTL).Unix()
+	m.db.Model(&schemaMigrationLock{}).UpdateColumn("locked_at", old)
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	l := schemaMigrationLock{}
+	m.db.First(&l)
+	if l.Owner != m.owner || l.LockedAt <= old {
+		t.Fatalf("expect lock refreshed, got %+v", l)
+	}
+
+	// 只释放自己持有的锁
+	o := &Migrator{name: m.name, db: m.db, owner: "other"}
+	o.unlock()
+	if n := 0; m.db.Model(&schemaMigrationLock{}).Count(&n).Error != nil || n != 1 {
+		t.Fatal("expect lock kept")
+	}
+	m.unlock()
+	if n := 0; m.db.Model(&schemaMigrationLock{}).Count(&n).Error != nil || n != 0 {
+		t.Fatal("expect lock released")
+	}
+}
+
+func TestMigrateCreateDir(t *testing.T) {
+	c := Config{"migrate_nodir": ConnConfig{}}
+	if _, err := createDir(c, "migrate_none"); err != errDBNotFound {
+		t.Fatalf("expect errDBNotFound, got %v", err)
+	}
+	if _, err := createDir(c, "migrate_nodir"); err != errMigrationDir {
+		t.Fatalf("expect errMigrationDir, got %v", err)
+	}
+
+	c["migrate_a"] = ConnConfig{MigrationDir: "a"}
+	if d, err := createDir(c, ""); d != "a" || err != nil {
+		t.Fatalf("expect the only migration dir, got %s %v", d, err)
+	}
+	c["migrate_b"] = ConnConfig{MigrationDir: "b"}
+	if _, err := createDir(c, ""); err != errMigrationConn {
+		t.Fatalf("expect errMigrationConn, got %v", err)
+	}
+}
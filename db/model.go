@@ -7,7 +7,11 @@ type Model interface {
 }
 
 func ReadModel(m Model) (*gorm.DB, error) {
-	r, e := Read(m.ConnectionName())
+	name, e := modelConn(m)
+	if e != nil {
+		return nil, e
+	}
+	r, e := Read(name)
 	if e != nil {
 		return nil, e
 	}
@@ -15,7 +19,11 @@ func ReadModel(m Model) (*gorm.DB, error) {
 }
 
 func WriteModel(m Model) (*gorm.DB, error) {
-	r, e := Write(m.ConnectionName())
+	name, e := modelConn(m)
+	if e != nil {
+		return nil, e
+	}
+	r, e := Write(name)
 	if e != nil {
 		return nil, e
 	}
@@ -24,10 +32,18 @@ func WriteModel(m Model) (*gorm.DB, error) {
 
 func MustReadModel(m Model) *gorm.DB {
 
-	return MustRead(m.ConnectionName()).Model(m)
+	return MustRead(mustModelConn(m)).Model(m)
 
 }
 
 func MustWriteModel(m Model) *gorm.DB {
-	return MustWrite(m.ConnectionName()).Model(m)
+	return MustWrite(mustModelConn(m)).Model(m)
+}
+
+func mustModelConn(m Model) string {
+	name, e := modelConn(m)
+	if e != nil {
+		panic(e)
+	}
+	return name
 }
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+乐观锁
+
+模型嵌入 Versioned 后, 带主键的更新加上 version = 当前值 的条件并将 version 加 1, Unscoped 时同样检查
+不带主键的批量更新不检查, 只将 version 加 1
+记录存在但没有更新到时返回 ErrStaleObject, 模型中的 version 恢复为原值; 记录不存在时 Save 照常插入
+ErrStaleObject 可以被 WithTxRetry/ExecTransRetry 重试, 重试时需要在事务函数中重新读取记录
+
+*/
+
+const (
+	versionField   = "Version"
+	versionKey     = "db:version"
+	versionSkipKey = "db:version_skip"
+)
+
+var (
+	ErrStaleObject = errors.New("Stale Object")
+)
+
+type Versioned struct {
+	Version int64 `gorm:"column:version;not null"`
+}
+
+func (Versioned) versioned() {}
+
+type versioner interface {
+	versioned()
+}
+
+type StaleObjectError struct {
+	Table      string
+	PrimaryKey interface{}
+	Version    int64
+}
+
+func (e *StaleObjectError) Error() string {
+	return fmt.Sprintf("%s : %s %v version %d", ErrStaleObject, e.Table, e.PrimaryKey, e.Version)
+}
+
+func (e *StaleObjectError) Is(target error) bool {
+	return target == ErrStaleObject
+}
+
+func isVersioned(scope *gorm.Scope) bool {
+	t := scope.GetModelStruct().ModelType
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := reflect.New(t).Interface().(versioner)
+	return ok
+}
+
+func versionBefore(scope *gorm.Scope) {
+	if scope.HasError() || !isVersioned(scope) {
+		return
+	}
+	if _, ok := scope.Get(versionSkipKey); ok {
+		return
+	}
+	f, ok := scope.FieldByName(versionField)
+	if !ok {
+		return
+	}
+	if scope.PrimaryKeyZero() {
+		if attrs, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+			attrs.(map[string]interface{})[f.DBName] = gorm.Expr(scope.Quote(f.DBName) + " + 1")
+		}
+		return
+	}
+	cur := f.Field.Int()
+	scope.Search.Where(fmt.Sprintf("%v.%v = ?", softDeleteAlias(scope), scope.Quote(f.DBName)), cur)
+	if err := scope.SetColumn(versionField, cur+1); err != nil {
+		scope.Err(err)
+		return
+	}
+	scope.InstanceSet(versionKey, cur)
+}
+
+func versionAfter(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(versionKey)
+	if !ok {
+		return
+	}
+	cur := v.(int64)
+	if scope.HasError() || scope.DB().RowsAffected == 0 {
+		if f, ok := scope.FieldByName(versionField); ok {
+			f.Set(cur)
+		}
+	}
+	if !scope.HasError() && scope.DB().RowsAffected == 0 && versionExists(scope) {
+		scope.Err(&StaleObjectError{Table: scope.TableName(), PrimaryKey: scope.PrimaryKeyValue(), Version: cur})
+	}
+}
+
+// versionExists 没有更新到记录时按主键确认记录存在, 包括已软删除的记录
+func versionExists(scope *gorm.Scope) bool {
+	db := scope.NewDB().Unscoped().Table(auditTable(scope))
+	for _, f := range scope.PrimaryFields() {
+		db = db.Where(fmt.Sprintf("%v = ?", scope.Quote(f.DBName)), f.Field.Interface())
+	}
+	n := 0
+	if err := db.Count(&n).Error; err != nil {
+		scope.Err(err)
+		return false
+	}
+	return n > 0
+}
+
+func registerVersion(c *gorm.Callback) {
+	c.Update().Before("gorm:update").Register("my:version", versionBefore)
+	c.Update().After("gorm:update").Register("my:version_check", versionAfter)
+}
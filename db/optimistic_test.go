@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type verItem struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name"`
+	Versioned
+	SoftDelete
+}
+
+func (verItem) TableName() string {
+	return "ver_item"
+}
+
+func TestOptimisticConflict(t *testing.T) {
+	d := openTables(t, "ver", ConnConfig{}, []interface{}{&verItem{}}).Write()
+
+	// 新记录带主键时 Save 插入
+	if err := d.Save(&verItem{ID: 1, Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	a, b := verItem{}, verItem{}
+	d.First(&a, 1)
+	d.First(&b, 1)
+
+	a.Name = "b"
+	if err := d.Save(&a).Error; err != nil || a.Version != 1 {
+		t.Fatalf("expect version bumped, got %d %v", a.Version, err)
+	}
+	b.Name = "c"
+	err := d.Save(&b).Error
+	var se *StaleObjectError
+	if !errors.Is(err, ErrStaleObject) || !errors.As(err, &se) || se.Version != 0 {
+		t.Fatalf("expect ErrStaleObject, got %v", err)
+	}
+	if b.Version != 0 {
+		t.Fatalf("expect version restored, got %d", b.Version)
+	}
+
+	// Unscoped 时同样检查
+	if err := d.Unscoped().Model(&b).Update("name", "d").Error; !errors.Is(err, ErrStaleObject) {
+		t.Fatalf("expect Unscoped update checked, got %v", err)
+	}
+}
+
+func TestOptimisticBatch(t *testing.T) {
+	d := openTables(t, "ver_batch", ConnConfig{}, []interface{}{&verItem{}}).Write()
+	d.Create(&verItem{ID: 1})
+	d.Create(&verItem{ID: 2})
+
+	if err := d.Model(&verItem{}).Where("id > ?", 0).Update("name", "x").Error; err != nil {
+		t.Fatal(err)
+	}
+	rs := []verItem{}
+	d.Order("id").Find(&rs)
+	if len(rs) != 2 || rs[0].Version != 1 || rs[1].Version != 1 {
+		t.Fatalf("expect batch update to bump versions, got %+v", rs)
+	}
+
+	// 恢复软删除的记录不检查 version
+	d.Delete(&verItem{ID: 1})
+	if err := Restore(d, &verItem{ID: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if d.Model(&verItem{}).Count(&n); n != 2 {
+		t.Fatalf("expect row restored, got %d", n)
+	}
+}
+
+func TestOptimisticRetry(t *testing.T) {
+	openTables(t, "ver_retry", ConnConfig{}, []interface{}{&verItem{}}, &verItem{ID: 1})
+	ctx := context.Background()
+
+	attempts := 0
+	err := WithTxRetry(ctx, "ver_retry", Retry{Attempts: 3, Backoff: time.Millisecond}, func(ctx context.Context) error {
+		attempts++
+		i := verItem{}
+		MustReadContext(ctx, "ver_retry").First(&i, 1)
+		if attempts == 1 {
+			// 读取之后被其他写入修改
+			MustWriteContext(ctx, "ver_retry").Model(&verItem{}).Where("id = ?", 1).Update("name", "other")
+		}
+		i.Name = "mine"
+		return MustWriteContext(ctx, "ver_retry").Save(&i).Error
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("expect success on retry, got %d %v", attempts, err)
+	}
+	i := verItem{}
+	MustRead("ver_retry").First(&i, 1)
+	if i.Name != "mine" || i.Version != 1 {
+		t.Fatalf("expect the retried write, got %+v", i)
+	}
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultPageSize  = 20
+	defaultBatchSize = 500
+
+	upsertConflictKey = "db:upsert_conflict"
+)
+
+var (
+	// 单条语句最多的占位符数量
+	maxPlaceholders = map[string]int{
+		"mysql":      65535,
+		DriverSQLite: 32766,
+		"mssql":      2100,
+		"postgres":   65535,
+	}
+)
+
+var (
+	errCursor           = errors.New("Cursor Error")
+	errSliceOut         = errors.New("Out Must Be A Slice Pointer")
+	errUpsertNotSupport = errors.New("Upsert Not Supported")
+)
+
+type Page struct {
+	Items      interface{} `json:"items"`
+	Page       int         `json:"page,omitempty"`
+	Size       int         `json:"size"`
+	Total      int64       `json:"total,omitempty"`
+	HasMore    bool        `json:"has_more"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// CursorQuery 游标分页, Column 需要唯一且可排序, 默认主键
+type CursorQuery struct {
+	Column string
+	Desc   bool
+	Cursor string // 上一页的 NextCursor, 第一页为空
+	Size   int
+}
+
+func sliceOut(out interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return rv, errSliceOut
+	}
+	return rv.Elem(), nil
+}
+
+// Paginate 偏移分页, page 从 1 开始
+func Paginate(db *gorm.DB, out interface{}, page, size int) (*Page, error) {
+	if _, err := sliceOut(out); err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	var total int64
+	if err := db.Model(out).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Offset((page - 1) * size).Limit(size).Find(out).Error; err != nil {
+		return nil, err
+	}
+	return &Page{
+		Items:   out,
+		Page:    page,
+		Size:    size,
+		Total:   total,
+		HasMore: int64(page*size) < total,
+	}, nil
+}
+
+// CursorPaginate 游标分页, 不受翻页时数据增删的影响, 也不需要 COUNT
+func CursorPaginate(db *gorm.DB, out interface{}, q CursorQuery) (*Page, error) {
+	rv, err := sliceOut(out)
+	if err != nil {
+		return nil, err
+	}
+	if q.Size <= 0 {
+		q.Size = defaultPageSize
+	}
+	scope := db.NewScope(out)
+	if q.Column == "" {
+		q.Column = scope.PrimaryKey()
+	}
+	column := fmt.Sprintf("%v.%v", scope.QuotedTableName(), scope.Quote(q.Column))
+
+	op, order := ">", "ASC"
+	if q.Desc {
+		op, order = "<", "DESC"
+	}
+	if q.Cursor != "" {
+		v, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where(column+" "+op+" ?", v)
+	}
+	if err := db.Order(column + " " + order).Limit(q.Size + 1).Find(out).Error; err != nil {
+		return nil, err
+	}
+
+	p := &Page{Items: out, Size: q.Size}
+	if rv.Len() > q.Size {
+		rv.Set(rv.Slice(0, q.Size))
+		p.HasMore = true
+		last := db.NewScope(rv.Index(q.Size - 1).Addr().Interface())
+		f, ok := last.FieldByName(q.Column)
+		if !ok {
+			return nil, errCursor
+		}
+		if p.NextCursor, err = encodeCursor(f.Field.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func encodeCursor(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(c string) (interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return nil, errCursor
+	}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, errCursor
+	}
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		return n.Float64()
+	}
+	return v, nil
+}
+
+// FindInBatches 按主键顺序每次查询 size 条到 out 并调用 f, f 返回错误或 ctx 结束时停止
+func FindInBatches(ctx context.Context, db *gorm.DB, out interface{}, size int, f func(batch int) error) error {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	q := CursorQuery{Size: size}
+	for batch := 0; ; batch++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		p, err := CursorPaginate(db, out, q)
+		if err != nil {
+			return err
+		}
+		if reflect.ValueOf(out).Elem().Len() == 0 {
+			return nil
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+		if !p.HasMore {
+			return nil
+		}
+		q.Cursor = p.NextCursor
+	}
+}
+
+// BulkInsert 多行插入, 按各数据库的占位符上限分批执行, rows 为结构体切片
+// 分多批时在同一事务中执行 (已在事务中时使用 savepoint), 全部成功或全部回滚
+// 时间戳与操作人字段按连接配置填充; 不经过 gorm 回调, 不记录审计日志
+// 主键为零的行由数据库生成主键, 与指定了主键的行分成不同的语句
+func BulkInsert(db *gorm.DB, rows interface{}) error {
+	return bulkInsert(db, rows, false, nil)
+}
+
+// BulkUpsert 冲突时更新 columns, 为空时更新所有插入的非主键字段, 不包括创建时间与创建人
+// 支持 mysql 与 sqlite3; mysql 任意主键或唯一键冲突时更新,
+// sqlite 默认只判断主键, 按唯一键判断时通过 UpsertConflict 指定唯一键的列
+func BulkUpsert(db *gorm.DB, rows interface{}, columns ...string) error {
+	return bulkInsert(db, rows, true, columns)
+}
+
+// UpsertConflict sqlite 的 BulkUpsert 按 columns 判断冲突, columns 需要是主键或唯一键
+func UpsertConflict(columns ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(upsertConflictKey, columns)
+	}
+}
+
+func bulkInsert(db *gorm.DB, rows interface{}, upsert bool, columns []string) error {
+	rv := reflect.Indirect(reflect.ValueOf(rows))
+	if rv.Kind() != reflect.Slice {
+		return errSliceOut
+	}
+	if rv.Len() == 0 {
+		return nil
+	}
+	dialect := db.Dialect().GetName()
+	if upsert && dialect != "mysql" && dialect != DriverSQLite {
+		return errUpsertNotSupport
+	}
+
+	c := defaultCallbackConfig
+	if v, ok := db.Get(callbackConfigKey); ok {
+		c = v.(callbackConfig)
+	}
+	actor, hasActor := db.Get(actorKey)
+	now := c.now()
+
+	// 主键为零的行不插入主键列, 与指定了主键的行分成不同的语句, 先插入指定了主键的行
+	groups := [2][]*gorm.Scope{}
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		}
+		s := db.NewScope(e.Interface())
+		for _, name := range []string{c.createTime, c.updateTime} {
+			if f, ok := s.FieldByName(name); ok && f.IsBlank {
+				f.Set(now)
+			}
+		}
+		if hasActor {
+			for _, name := range []string{c.createdBy, c.updatedBy} {
+				if f, ok := s.FieldByName(name); ok && f.IsBlank {
+					f.Set(actor)
+				}
+			}
+		}
+		if s.PrimaryKeyZero() {
+			groups[1] = append(groups[1], s)
+		} else {
+			groups[0] = append(groups[0], s)
+		}
+	}
+
+	sqls := []string{}
+	varss := [][]interface{}{}
+	table := ""
+	for i, scopes := range groups {
+		if len(scopes) == 0 {
+			continue
+		}
+		table = scopes[0].TableName()
+		ss, vs, err := bulkSQL(db, scopes, i == 1, upsert, columns, c)
+		if err != nil {
+			return err
+		}
+		sqls = append(sqls, ss...)
+		varss = append(varss, vs...)
+	}
+
+	if len(sqls) == 1 {
+		if err := db.Exec(sqls[0], varss[0]...).Error; err != nil {
+			return err
+		}
+	} else {
+		err := ExecTrans(db, func(tx *gorm.DB) error {
+			for i, sql := range sqls {
+				if err := tx.Exec(sql, varss[i]...).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	invalidateTag(db, c.conn+"|"+table)
+	return nil
+}
+
+// bulkSQL 按占位符上限把 scopes 分批生成 INSERT 语句, skipPK 时不插入主键列
+func bulkSQL(db *gorm.DB, scopes []*gorm.Scope, skipPK, upsert bool, columns []string, c callbackConfig) ([]string, [][]interface{}, error) {
+	dialect := db.Dialect().GetName()
+	fields := []*gorm.Field{}
+	for _, f := range scopes[0].Fields() {
+		if !f.IsNormal || f.IsIgnored || (f.IsPrimaryKey && skipPK) {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, nil, errSliceOut
+	}
+	cols := make([]string, 0, len(fields))
+	holders := make([]string, 0, len(fields))
+	for _, f := range fields {
+		cols = append(cols, scopes[0].Quote(f.DBName))
+		holders = append(holders, "?")
+	}
+	row := "(" + strings.Join(holders, ",") + ")"
+
+	suffix := ""
+	if upsert {
+		if len(columns) == 0 {
+			for _, f := range fields {
+				if !f.IsPrimaryKey && f.Name != c.createTime && f.Name != c.createdBy {
+					columns = append(columns, f.DBName)
+				}
+			}
+		}
+		var conflict []string
+		if v, ok := db.Get(upsertConflictKey); ok {
+			conflict = v.([]string)
+		}
+		suffix = upsertClause(scopes[0], dialect, columns, conflict)
+	}
+
+	max, ok := maxPlaceholders[dialect]
+	if !ok {
+		max = maxPlaceholders["mssql"]
+	}
+	chunk := max / len(fields)
+	if chunk == 0 {
+		chunk = 1
+	}
+	sqls := []string{}
+	varss := [][]interface{}{}
+	for start := 0; start < len(scopes); start += chunk {
+		end := start + chunk
+		if end > len(scopes) {
+			end = len(scopes)
+		}
+		values := make([]string, 0, end-start)
+		vars := make([]interface{}, 0, (end-start)*len(fields))
+		for _, s := range scopes[start:end] {
+			values = append(values, row)
+			for _, f := range fields {
+				sf, _ := s.FieldByName(f.Name)
+				vars = append(vars, sf.Field.Interface())
+			}
+		}
+		sqls = append(sqls, fmt.Sprintf("INSERT INTO %v (%v) VALUES %v%v",
+			scopes[0].QuotedTableName(), strings.Join(cols, ","), strings.Join(values, ","), suffix))
+		varss = append(varss, vars)
+	}
+	return sqls, varss, nil
+}
+
+func upsertClause(scope *gorm.Scope, dialect string, columns, conflict []string) string {
+	sets := make([]string, 0, len(columns))
+	if dialect == "mysql" {
+		for _, c := range columns {
+			q := scope.Quote(c)
+			sets = append(sets, fmt.Sprintf("%v=VALUES(%v)", q, q))
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+	}
+	for _, c := range columns {
+		q := scope.Quote(c)
+		sets = append(sets, fmt.Sprintf("%v=excluded.%v", q, q))
+	}
+	if len(conflict) == 0 {
+		for _, f := range scope.PrimaryFields() {
+			conflict = append(conflict, f.DBName)
+		}
+	}
+	keys := make([]string, 0, len(conflict))
+	for _, c := range conflict {
+		keys = append(keys, scope.Quote(c))
+	}
+	return fmt.Sprintf(" ON CONFLICT (%v) DO UPDATE SET %v", strings.Join(keys, ","), strings.Join(sets, ","))
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+type pageItem struct {
+	ID         int64  `gorm:"column:id;primary_key"`
+	Email      string `gorm:"column:email;unique_index"`
+	Name       string `gorm:"column:name"`
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+	CreatedBy  string `gorm:"column:created_by"`
+}
+
+func (pageItem) TableName() string {
+	return "page_item"
+}
+
+// pageSeeds id 为 1 到 n 的行
+func pageSeeds(n int) []interface{} {
+	r := make([]interface{}, 0, n)
+	for i := 1; i <= n; i++ {
+		r = append(r, &testItem{ID: int64(i)})
+	}
+	return r
+}
+
+func TestPaginate(t *testing.T) {
+	d := openTables(t, "page", ConnConfig{}, []interface{}{&testItem{}}, pageSeeds(5)...).Write()
+
+	rs := []testItem{}
+	p, err := Paginate(d.Order("id"), &rs, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 5 || !p.HasMore || len(rs) != 2 || rs[0].ID != 3 {
+		t.Fatalf("expect the 2nd page, got %+v %+v", p, rs)
+	}
+	if _, err := Paginate(d, rs, 1, 2); err != errSliceOut {
+		t.Fatalf("expect errSliceOut, got %v", err)
+	}
+}
+
+func TestCursorPaginate(t *testing.T) {
+	d := openTables(t, "page_cursor", ConnConfig{}, []interface{}{&testItem{}}, pageSeeds(5)...).Write()
+
+	got := []int64{}
+	q := CursorQuery{Size: 2, Desc: true}
+	for {
+		rs := []testItem{}
+		p, err := CursorPaginate(d, &rs, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rs {
+			got = append(got, r.ID)
+		}
+		if !p.HasMore {
+			break
+		}
+		q.Cursor = p.NextCursor
+	}
+	if len(got) != 5 || got[0] != 5 || got[4] != 1 {
+		t.Fatalf("expect all rows in desc order, got %v", got)
+	}
+	if _, err := CursorPaginate(d, &[]testItem{}, CursorQuery{Cursor: "!"}); err != errCursor {
+		t.Fatalf("expect errCursor, got %v", err)
+	}
+}
+
+func TestFindInBatches(t *testing.T) {
+	d := openTables(t, "page_batch", ConnConfig{}, []interface{}{&testItem{}}, pageSeeds(5)...).Write()
+
+	rs := []testItem{}
+	sizes := []int{}
+	err := FindInBatches(context.Background(), d, &rs, 2, func(int) error {
+		sizes = append(sizes, len(rs))
+		return nil
+	})
+	if err != nil || len(sizes) != 3 || sizes[2] != 1 {
+		t.Fatalf("expect batches of 2, 2, 1, got %v %v", sizes, err)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	d := openTables(t, "bulk", ConnConfig{}, []interface{}{&pageItem{}}).Write()
+
+	rs := []pageItem{{Email: "a"}, {Email: "b"}}
+	if err := BulkInsert(SetActor(d, "alice"), rs); err != nil {
+		t.Fatal(err)
+	}
+	out := []pageItem{}
+	d.Order("id").Find(&out)
+	if len(out) != 2 || out[1].ID != 2 || out[0].CreateTime == 0 || out[0].CreatedBy != "alice" {
+		t.Fatalf("expect rows with timestamps and actor, got %+v", out)
+	}
+}
+
+func TestBulkInsertMixedPK(t *testing.T) {
+	d := openTables(t, "bulk_mixed", ConnConfig{}, []interface{}{&pageItem{}}).Write()
+
+	// 主键为零的行不插入 id = 0
+	rs := []pageItem{{Email: "a"}, {ID: 5, Email: "b"}, {Email: "c"}}
+	if err := BulkInsert(d, rs); err != nil {
+		t.Fatal(err)
+	}
+	out := []pageItem{}
+	d.Order("id").Find(&out)
+	if len(out) != 3 || out[0].ID != 5 || out[1].ID != 6 || out[2].ID != 7 {
+		t.Fatalf("expect generated ids after the explicit one, got %+v", out)
+	}
+}
+
+func TestBulkInsertAtomic(t *testing.T) {
+	d := openTables(t, "bulk_atomic", ConnConfig{}, []interface{}{&pageItem{}}).Write()
+	old := maxPlaceholders[DriverSQLite]
+	maxPlaceholders[DriverSQLite] = 12
+	defer func() { maxPlaceholders[DriverSQLite] = old }()
+
+	// 每批 2 行, 最后一批主键冲突时之前的批次也回滚
+	rs := []pageItem{{ID: 1, Email: "a"}, {ID: 2, Email: "b"}, {ID: 3, Email: "c"}, {ID: 1, Email: "d"}}
+	if err := BulkInsert(d, rs); err == nil {
+		t.Fatal("expect duplicate key error")
+	}
+	n := 0
+	if d.Model(&pageItem{}).Count(&n); n != 0 {
+		t.Fatalf("expect all chunks rolled back, got %d", n)
+	}
+	if err := BulkInsert(d, rs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if d.Model(&pageItem{}).Count(&n); n != 3 {
+		t.Fatalf("expect all chunks inserted, got %d", n)
+	}
+}
+
+func TestBulkUpsert(t *testing.T) {
+	d := openTables(t, "bulk_upsert", ConnConfig{}, []interface{}{&pageItem{}}).Write()
+	d.Create(&pageItem{ID: 1, Email: "a", Name: "old", CreatedBy: "alice"})
+	d.Model(&pageItem{ID: 1}).UpdateColumns(map[string]interface{}{"create_time": 1, "update_time": 1})
+
+	rs := []pageItem{{ID: 1, Email: "a", Name: "new"}, {ID: 2, Email: "b", Name: "new"}}
+	if err := BulkUpsert(SetActor(d, "bob"), rs); err != nil {
+		t.Fatal(err)
+	}
+	i := pageItem{}
+	d.First(&i, 1)
+	if i.Name != "new" || i.CreateTime != 1 || i.CreatedBy != "alice" || i.UpdateTime <= 1 {
+		t.Fatalf("expect create fields kept on upsert, got %+v", i)
+	}
+
+	// 按唯一键判断冲突
+	rs = []pageItem{{ID: 3, Email: "b", Name: "unique"}}
+	if err := BulkUpsert(d.Scopes(UpsertConflict("email")), rs, "name"); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	d.Model(&pageItem{}).Count(&n)
+	j := pageItem{}
+	d.First(&j, 2)
+	if n != 2 || j.Name != "unique" {
+		t.Fatalf("expect the row with the same email updated, got %d %+v", n, j)
+	}
+}
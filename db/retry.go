@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	mysqlDeadlock        = 1213
+	mysqlLockWaitTimeout = 1205
+
+	mssqlDeadlock        = 1205
+	mssqlLockWaitTimeout = 1222
+
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+)
+
+var (
+	retryTimes uint64
+)
+
+// Retry 事务遇到死锁或锁等待超时时整体重试, Attempts 包含第一次执行
+type Retry struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var (
+	DefaultRetry = Retry{Attempts: 3, Backoff: defaultRetryBackoff, MaxBackoff: defaultRetryMaxBackoff}
+)
+
+type mssqlError interface {
+	SQLErrorNumber() int32
+}
+
+// IsRetryable 判断是否为可以重试整个事务的错误, 包括乐观锁冲突
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrStaleObject) {
+		return true
+	}
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == mysqlDeadlock || me.Number == mysqlLockWaitTimeout
+	}
+	var se mssqlError
+	if errors.As(err, &se) {
+		n := se.SQLErrorNumber()
+		return n == mssqlDeadlock || n == mssqlLockWaitTimeout
+	}
+	return false
+}
+
+// TransRetries 返回事务重试的总次数
+func TransRetries() uint64 {
+	return atomic.LoadUint64(&retryTimes)
+}
+
+func (r Retry) wait(attempt int) time.Duration {
+	b := r.Backoff
+	if b <= 0 {
+		b = defaultRetryBackoff
+	}
+	max := r.MaxBackoff
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+	for i := 1; i < attempt && b < max; i++ {
+		b *= 2
+	}
+	if b > max {
+		b = max
+	}
+	return b/2 + time.Duration(rander.Intn(int(b/2)+1))
+}
+
+func (r Retry) do(ctx context.Context, f func() error) error {
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for i := 1; ; i++ {
+		if err = f(); err == nil || i >= attempts || !IsRetryable(err) {
+			return err
+		}
+		atomic.AddUint64(&retryTimes, 1)
+		d := r.wait(i)
+		fmt.Printf("[db] retry transaction %d/%d after %s : %s\n", i+1, attempts, d, err)
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func ExecTransRetry(db *gorm.DB, r Retry, trans ...Task) error {
+	return r.do(context.Background(), func() error {
+		return ExecTrans(db, trans...)
+	})
+}
+
+// WithTxRetry 只在最外层事务重试, 嵌套调用与 WithTx 相同; r 代替连接配置的重试, 不会与之叠加
+func WithTxRetry(ctx context.Context, name string, r Retry, f func(context.Context) error) error {
+	return withTx(ctx, name, &r, f)
+}
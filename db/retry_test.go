@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	if IsRetryable(nil) || IsRetryable(errTest) {
+		t.Fatal("expect plain errors not retryable")
+	}
+	if !IsRetryable(fmt.Errorf("%w : %s", ErrStaleObject, "item")) {
+		t.Fatal("expect wrapped ErrStaleObject retryable")
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	w := openItems(t, "tx_retry", ConnConfig{TxRetry: 3, TxRetryBackoff: 1})
+	r := Retry{Attempts: 2, Backoff: time.Millisecond}
+
+	// 连接配置的重试不与 WithTxRetry 叠加
+	calls := 0
+	before := TransRetries()
+	err := WithTxRetry(context.Background(), "tx_retry", r, func(ctx context.Context) error {
+		calls++
+		createItem(ctx, "tx_retry", int64(calls))
+		return ErrStaleObject
+	})
+	if err != ErrStaleObject || calls != 2 || TransRetries()-before != 1 {
+		t.Fatalf("expect 2 attempts, got %d %v", calls, err)
+	}
+	if n := countItems(t, w.Write()); n != 0 {
+		t.Fatalf("expect every attempt rolled back, got %d", n)
+	}
+
+	calls = 0
+	err = WithTx(context.Background(), "tx_retry", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return ErrStaleObject
+		}
+		return createItem(ctx, "tx_retry", 1)
+	})
+	if err != nil || calls != 3 || countItems(t, w.Write()) != 1 {
+		t.Fatalf("expect success on the 3rd attempt, got %d %v", calls, err)
+	}
+
+	// 嵌套调用不重试
+	calls = 0
+	WithTx(context.Background(), "tx_retry", func(ctx context.Context) error {
+		WithTxRetry(ctx, "tx_retry", r, func(context.Context) error {
+			calls++
+			return ErrStaleObject
+		})
+		return nil
+	})
+	if calls != 1 {
+		t.Fatalf("expect nested call run once, got %d", calls)
+	}
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	drainGrace    = time.Second // 已取得 *gorm.DB 还未执行查询的请求
+	drainTimeout  = 30 * time.Second
+	drainInterval = 100 * time.Millisecond
+)
+
+var (
+	errDBExists = errors.New("DB Exists")
+)
+
+// Register 运行时增加连接
+func Register(name string, config ConnConfig) error {
+	if _, ok := lookup(name); ok {
+		return errDBExists
+	}
+	w, err := newWrapper(name, config)
+	if err != nil {
+		return err
+	}
+	if err := w.boot(); err != nil {
+		return err
+	}
+	holderMu.Lock()
+	defer holderMu.Unlock()
+	if _, ok := dbHolder[name]; ok {
+		go w.drain()
+		return errDBExists
+	}
+	dbHolder[name] = w
+	return nil
+}
+
+// Replace 使用新的配置替换连接, 不存在时增加
+// 只有连接池大小或 slave 变化时原地修改, 否则先建立新连接再替换, 旧连接在请求结束后关闭
+func Replace(name string, config ConnConfig) error {
+	old, ok := lookup(name)
+	if !ok {
+		return Register(name, config)
+	}
+	config.Driver = driverName(config.Driver)
+	if inPlace(old.conf(), config) {
+		return old.reconfigure(config)
+	}
+
+	w, err := newWrapper(name, config)
+	if err != nil {
+		return err
+	}
+	if err := w.boot(); err != nil {
+		return err
+	}
+	setHolder(name, w)
+	go old.drain()
+	fmt.Printf("[db] %s replaced\n", name)
+	return nil
+}
+
+// Remove 移除连接, 连接在请求结束后关闭
+func Remove(name string) error {
+	holderMu.Lock()
+	w, ok := dbHolder[name]
+	delete(dbHolder, name)
+	holderMu.Unlock()
+
+	if !ok {
+		return errDBNotFound
+	}
+	go w.drain()
+	fmt.Printf("[db] %s removed\n", name)
+	return nil
+}
+
+// Update 与当前的连接比较, 增加, 替换或移除连接
+func Update(c Config) error {
+	var err error
+	for _, name := range Names() {
+		if _, ok := c[name]; ok {
+			continue
+		}
+		if e := Remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	for name, config := range c {
+		if w, ok := lookup(name); ok && reflect.DeepEqual(w.conf(), normalize(config)) {
+			continue
+		}
+		if e := Replace(name, config); e != nil {
+			fmt.Printf("[db] update %s : %s\n", name, e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func normalize(c ConnConfig) ConnConfig {
+	c.Driver = driverName(c.Driver)
+	return c
+}
+
+// inPlace 只有连接池大小或 slave 不同
+func inPlace(old, new ConnConfig) bool {
+	for _, c := range []*ConnConfig{&old, &new} {
+		c.ConnMaxLifeTime, c.MaxIdleConns, c.MaxOpenConns = 0, 0, 0
+		c.Slave = nil
+	}
+	return reflect.DeepEqual(old, new)
+}
+
+func (w *Wrapper) reconfigure(config ConnConfig) error {
+	w.connMu.Lock()
+	defer w.connMu.Unlock()
+
+	w.mu.Lock()
+	w.config = config
+	w.mu.Unlock()
+
+	if d := w.master(); d != nil {
+		setPool(d, w.driver, config.Source, config)
+	}
+
+	current := map[string]*Slave{}
+	for _, s := range w.Slaves() {
+		current[s.source] = s
+	}
+	slaves := make([]*Slave, 0, len(config.Slave))
+	for _, sc := range config.Slave {
+		weight := sc.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		if s, ok := current[sc.Source]; ok {
+			delete(current, sc.Source)
+			if d := s.DB(); d != nil {
+				setPool(d, w.driver, s.source, config)
+			}
+			if s.weight != weight {
+				// balancer 并发读取 weight, 使用新的 Slave 共享连接
+				s = &Slave{db: s.DB(), source: s.source, weight: weight, down: atomic.LoadInt32(&s.down)}
+			}
+			slaves = append(slaves, s)
+			continue
+		}
+		s := &Slave{source: sc.Source, weight: weight, down: 1}
+		if err := w.openSlave(s); err != nil {
+			fmt.Printf("[db] slave of %s connect failed : %s\n", w.name, err)
+		}
+		slaves = append(slaves, s)
+	}
+
+	w.mu.Lock()
+	w.slave = slaves
+	w.mu.Unlock()
+
+	for _, s := range current {
+		go s.drain()
+	}
+	if !w.connected() {
+		w.startReconnect()
+	}
+	fmt.Printf("[db] %s reconfigured\n", w.name)
+	return nil
+}
+
+// drain 等待正在使用的连接归还后关闭
+func (w *Wrapper) drain() {
+	w.closeOnce.Do(func() { close(w.stop) })
+	time.Sleep(drainGrace)
+	deadline := time.Now().Add(drainTimeout)
+	for time.Now().Before(deadline) && w.inUse() > 0 {
+		time.Sleep(drainInterval)
+	}
+	if err := w.Close(); err != nil {
+		fmt.Printf("[db] close %s : %s\n", w.name, err)
+	}
+}
+
+func (w *Wrapper) inUse() int {
+	n := 0
+	if d := w.master(); d != nil {
+		n += d.DB().Stats().InUse
+	}
+	for _, s := range w.Slaves() {
+		n += s.Stats().InUse
+	}
+	return n
+}
+
+func (s *Slave) drain() {
+	s.setHealthy(false)
+	d := s.DB()
+	if d == nil {
+		return
+	}
+	time.Sleep(drainGrace)
+	deadline := time.Now().Add(drainTimeout)
+	for time.Now().Before(deadline) && s.Stats().InUse > 0 {
+		time.Sleep(drainInterval)
+	}
+	if err := d.Close(); err != nil {
+		fmt.Printf("[db] close slave %s : %s\n", s.source, err)
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitClosed(t *testing.T, w *Wrapper) {
+	t.Helper()
+	deadline := time.Now().Add(drainGrace + 5*time.Second)
+	for w.master().DB().Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the old connection closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRuntimeRegister(t *testing.T) {
+	openTest(t, "rt", ConnConfig{})
+	if err := Register("rt", ConnConfig{Driver: DriverSQLite, Source: ":memory:"}); err != errDBExists {
+		t.Fatalf("expect errDBExists, got %v", err)
+	}
+	if err := Remove("rt_none"); err != errDBNotFound {
+		t.Fatalf("expect errDBNotFound, got %v", err)
+	}
+
+	w, _ := lookup("rt")
+	if err := Remove("rt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Write("rt"); err != errDBNotFound {
+		t.Fatalf("expect removed, got %v", err)
+	}
+	waitClosed(t, w)
+}
+
+func TestRuntimeReplace(t *testing.T) {
+	dir := t.TempDir()
+	c := ConnConfig{Driver: DriverSQLite, Source: filepath.Join(dir, "a.db"), MaxOpenConns: 2}
+	w := openItems(t, "rt_replace", c)
+
+	// 只改连接池与 slave 时原地修改
+	c.MaxOpenConns = 3
+	c.Slave = []SlaveConfig{{Source: c.Source}}
+	if err := Replace("rt_replace", c); err != nil {
+		t.Fatal(err)
+	}
+	if cur, _ := lookup("rt_replace"); cur != w {
+		t.Fatal("expect the wrapper reconfigured in place")
+	}
+	if n := w.Write().DB().Stats().MaxOpenConnections; n != 3 || len(w.Slaves()) != 1 || !w.Slaves()[0].Healthy() {
+		t.Fatalf("expect pool resized and slave added, got %d %d", n, len(w.Slaves()))
+	}
+
+	// 其他配置变化时替换连接, 旧连接稍后关闭
+	c.Source = filepath.Join(dir, "b.db")
+	if err := Replace("rt_replace", c); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := lookup("rt_replace")
+	if cur == w || cur.Write().HasTable(&testItem{}) {
+		t.Fatal("expect a new connection to the new source")
+	}
+	waitClosed(t, w)
+}
+
+func TestRuntimeUpdate(t *testing.T) {
+	openTest(t, "rt_keep", ConnConfig{})
+	openTest(t, "rt_drop", ConnConfig{})
+	keep, _ := lookup("rt_keep")
+
+	err := Update(Config{
+		"rt_keep": {Driver: "sqlite", Source: ":memory:"},
+		"rt_add":  {Driver: DriverSQLite, Source: ":memory:"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Remove("rt_add") })
+	if cur, _ := lookup("rt_keep"); cur != keep {
+		t.Fatal("expect unchanged connection kept")
+	}
+	if _, ok := lookup("rt_drop"); ok {
+		t.Fatal("expect missing connection removed")
+	}
+	if _, err := Write("rt_add"); err != nil {
+		t.Fatal(err)
+	}
+}
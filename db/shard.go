@@ -0,0 +1,384 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+分片
+
+逻辑名对应多个连接名, 按 key 选择其中一个
+
+  [db_shard.user]
+  Conns = ["user0", "user1"]
+  Strategy = "modulo"
+
+模型实现 ShardedModel 时, ConnectionName 返回逻辑名, ReadModel/WriteModel 按 ShardKey 选择连接
+
+*/
+
+const (
+	ShardModulo = "modulo"
+	ShardRange  = "range"
+	ShardHash   = "hash"
+
+	defaultShardReplicas = 100
+)
+
+var (
+	strategies = map[string]func(ShardItem) (ShardStrategy, error){
+		ShardModulo: newModuloStrategy,
+		ShardRange:  newRangeStrategy,
+		ShardHash:   newHashStrategy,
+	}
+	strategiesMu sync.RWMutex
+
+	shards   = map[string]*shard{}
+	shardsMu sync.RWMutex
+
+	errShardNotFound    = errors.New("Shard Not Found")
+	errStrategyNotFound = errors.New("Shard Strategy Not Found")
+	errShardKey         = errors.New("Shard Key Error")
+	errShardOutOfRange  = errors.New("Shard Key Out Of Range")
+	errGatherOut        = errors.New("Gather Out Must Be A Slice Pointer")
+)
+
+type ShardItem struct {
+	Conns    []string
+	Strategy string  // modulo, range or hash, default modulo
+	Ranges   []int64 // range: exclusive upper bound of each conn, ascending
+	Replicas int     // hash: virtual nodes of each conn, default 100
+}
+
+type ShardConfig map[string]ShardItem
+
+// ShardStrategy 返回 key 所在的 Conns 下标
+type ShardStrategy interface {
+	Pick(key interface{}) (int, error)
+}
+
+type ShardedModel interface {
+	Model
+	ShardKey() interface{}
+}
+
+type shard struct {
+	conns    []string
+	strategy ShardStrategy
+}
+
+type ShardCpnt struct{}
+
+func (ShardCpnt) Init(options ...interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	c, ok := options[0].(*ShardConfig)
+	if !ok {
+		return errConfig
+	}
+	for name, item := range *c {
+		if err := RegisterShard(name, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ShardCpnt) CfgKey() string {
+	return "db_shard"
+}
+
+func (ShardCpnt) CfgType() interface{} {
+	return ShardConfig{}
+}
+
+func (ShardCpnt) CfgUpdate(interface{}) {
+
+}
+
+func RegisterShardStrategy(name string, f func(ShardItem) (ShardStrategy, error)) {
+	strategiesMu.Lock()
+	strategies[name] = f
+	strategiesMu.Unlock()
+}
+
+// RegisterShard 注册或替换逻辑名
+func RegisterShard(logical string, item ShardItem) error {
+	if len(item.Conns) == 0 {
+		return errConfig
+	}
+	name := item.Strategy
+	if name == "" {
+		name = ShardModulo
+	}
+	strategiesMu.RLock()
+	f, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return errStrategyNotFound
+	}
+	s, err := f(item)
+	if err != nil {
+		return err
+	}
+
+	shardsMu.Lock()
+	shards[logical] = &shard{conns: append([]string{}, item.Conns...), strategy: s}
+	shardsMu.Unlock()
+	return nil
+}
+
+func getShard(logical string) (*shard, bool) {
+	shardsMu.RLock()
+	defer shardsMu.RUnlock()
+	s, ok := shards[logical]
+	return s, ok
+}
+
+// ShardName 返回 key 所在的连接名
+func ShardName(logical string, key interface{}) (string, error) {
+	s, ok := getShard(logical)
+	if !ok {
+		return "", errShardNotFound
+	}
+	i, err := s.strategy.Pick(key)
+	if err != nil {
+		return "", err
+	}
+	return s.conns[i], nil
+}
+
+// ShardNames 返回所有分片的连接名
+func ShardNames(logical string) ([]string, error) {
+	s, ok := getShard(logical)
+	if !ok {
+		return nil, errShardNotFound
+	}
+	return append([]string{}, s.conns...), nil
+}
+
+func Shard(logical string, key interface{}) (*Wrapper, error) {
+	name, err := ShardName(logical, key)
+	if err != nil {
+		return nil, err
+	}
+	return get(name)
+}
+
+func MustShard(logical string, key interface{}) *Wrapper {
+	w, err := Shard(logical, key)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// modelConn 模型的连接名, ShardedModel 按 ShardKey 选择分片
+func modelConn(m Model) (string, error) {
+	if sm, ok := m.(ShardedModel); ok {
+		if _, ok := getShard(m.ConnectionName()); ok {
+			return ShardName(m.ConnectionName(), sm.ShardKey())
+		}
+	}
+	return m.ConnectionName(), nil
+}
+
+// Scatter 在每个分片上并发执行 f, 返回第一个错误
+func Scatter(logical string, f func(name string, w *Wrapper) error) error {
+	names, err := ShardNames(logical)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			w, err := get(name)
+			if err == nil {
+				err = f(name, w)
+			}
+			errs[i] = err
+		}(i, name)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gather 在每个分片的读连接上执行 query 并查询到 out (切片指针), 结果按分片顺序合并
+func Gather(logical string, out interface{}, query func(*gorm.DB) *gorm.DB) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errGatherOut
+	}
+	names, err := ShardNames(logical)
+	if err != nil {
+		return err
+	}
+	parts := make([]reflect.Value, len(names))
+	index := map[string]int{}
+	for i, name := range names {
+		index[name] = i
+	}
+	err = Scatter(logical, func(name string, w *Wrapper) error {
+		part := reflect.New(rv.Elem().Type())
+		if err := query(w.Read()).Find(part.Interface()).Error; err != nil {
+			return err
+		}
+		parts[index[name]] = part.Elem()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	all := rv.Elem()
+	for _, p := range parts {
+		all = reflect.AppendSlice(all, p)
+	}
+	rv.Elem().Set(all)
+	return nil
+}
+
+func shardInt(key interface{}) (int64, bool) {
+	switch v := key.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+type moduloStrategy struct {
+	n int64
+}
+
+func newModuloStrategy(item ShardItem) (ShardStrategy, error) {
+	return moduloStrategy{n: int64(len(item.Conns))}, nil
+}
+
+// shardUint 取模使用的无符号值, 负数取绝对值
+func shardUint(key interface{}) (uint64, bool) {
+	switch v := key.(type) {
+	case uint:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	}
+	i, ok := shardInt(key)
+	if !ok {
+		return 0, false
+	}
+	if i < 0 {
+		return uint64(-(i + 1)) + 1, true
+	}
+	return uint64(i), true
+}
+
+// Pick 整数取模, 其他按 fnv 哈希取模
+func (s moduloStrategy) Pick(key interface{}) (int, error) {
+	if u, ok := shardUint(key); ok {
+		return int(u % uint64(s.n)), nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprint(key)))
+	return int(int64(h.Sum32()) % s.n), nil
+}
+
+type rangeStrategy struct {
+	bounds []int64
+}
+
+func newRangeStrategy(item ShardItem) (ShardStrategy, error) {
+	if len(item.Ranges) != len(item.Conns) {
+		return nil, errConfig
+	}
+	if !sort.SliceIsSorted(item.Ranges, func(i, j int) bool { return item.Ranges[i] < item.Ranges[j] }) {
+		return nil, errConfig
+	}
+	return rangeStrategy{bounds: append([]int64{}, item.Ranges...)}, nil
+}
+
+func (s rangeStrategy) Pick(key interface{}) (int, error) {
+	i, ok := shardInt(key)
+	if !ok {
+		return 0, errShardKey
+	}
+	n := sort.Search(len(s.bounds), func(j int) bool { return s.bounds[j] > i })
+	if n == len(s.bounds) {
+		return 0, errShardOutOfRange
+	}
+	return n, nil
+}
+
+type hashStrategy struct {
+	ring  []uint32
+	nodes map[uint32]int
+}
+
+func newHashStrategy(item ShardItem) (ShardStrategy, error) {
+	replicas := item.Replicas
+	if replicas <= 0 {
+		replicas = defaultShardReplicas
+	}
+	s := hashStrategy{nodes: map[uint32]int{}}
+	for i, conn := range item.Conns {
+		for r := 0; r < replicas; r++ {
+			h := crc32.ChecksumIEEE([]byte(conn + "#" + strconv.Itoa(r)))
+			if _, ok := s.nodes[h]; ok {
+				continue
+			}
+			s.nodes[h] = i
+			s.ring = append(s.ring, h)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+	return s, nil
+}
+
+// Pick 一致性哈希, 增减连接时只有相邻区间的 key 移动
+func (s hashStrategy) Pick(key interface{}) (int, error) {
+	h := crc32.ChecksumIEEE([]byte(fmt.Sprint(key)))
+	n := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if n == len(s.ring) {
+		n = 0
+	}
+	return s.nodes[s.ring[n]], nil
+}
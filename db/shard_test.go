@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestModuloStrategy(t *testing.T) {
+	s, _ := newModuloStrategy(ShardItem{Conns: []string{"a", "b", "c"}})
+	cases := map[interface{}]int{
+		4:                      1,
+		int64(-4):              1,
+		"5":                    2,
+		uint64(math.MaxInt64):  int(uint64(math.MaxInt64) % 3),
+		uint64(1 << 63):        int(uint64(1<<63) % 3),
+		uint64(math.MaxUint64): int(uint64(math.MaxUint64) % 3),
+		int64(math.MinInt64):   int(uint64(1<<63) % 3),
+	}
+	for k, want := range cases {
+		if i, err := s.Pick(k); err != nil || i != want {
+			t.Fatalf("expect %v on %d, got %d %v", k, want, i, err)
+		}
+	}
+	// 非整数按哈希, 结果稳定
+	i, _ := s.Pick("user")
+	if j, _ := s.Pick("user"); i != j || i < 0 || i > 2 {
+		t.Fatalf("expect stable hash pick, got %d %d", i, j)
+	}
+}
+
+func TestRangeStrategy(t *testing.T) {
+	if _, err := newRangeStrategy(ShardItem{Conns: []string{"a", "b"}, Ranges: []int64{10}}); err != errConfig {
+		t.Fatalf("expect errConfig on missing range, got %v", err)
+	}
+	if _, err := newRangeStrategy(ShardItem{Conns: []string{"a", "b"}, Ranges: []int64{20, 10}}); err != errConfig {
+		t.Fatalf("expect errConfig on unsorted ranges, got %v", err)
+	}
+
+	s, _ := newRangeStrategy(ShardItem{Conns: []string{"a", "b"}, Ranges: []int64{10, 20}})
+	for k, want := range map[interface{}]int{0: 0, 9: 0, 10: 1, "19": 1} {
+		if i, err := s.Pick(k); err != nil || i != want {
+			t.Fatalf("expect %v on %d, got %d %v", k, want, i, err)
+		}
+	}
+	if _, err := s.Pick(20); err != errShardOutOfRange {
+		t.Fatalf("expect errShardOutOfRange, got %v", err)
+	}
+	if _, err := s.Pick(uint64(math.MaxUint64)); err != errShardKey {
+		t.Fatalf("expect errShardKey for overflowing key, got %v", err)
+	}
+	if _, err := s.Pick("x"); err != errShardKey {
+		t.Fatalf("expect errShardKey, got %v", err)
+	}
+}
+
+func TestHashStrategy(t *testing.T) {
+	s3, _ := newHashStrategy(ShardItem{Conns: []string{"a", "b", "c"}})
+	s4, _ := newHashStrategy(ShardItem{Conns: []string{"a", "b", "c", "d"}})
+
+	used := map[int]int{}
+	moved := 0
+	for k := 0; k < 1000; k++ {
+		i, _ := s3.Pick(k)
+		j, _ := s4.Pick(k)
+		used[i]++
+		if i != j {
+			moved++
+			if j != 3 {
+				t.Fatalf("expect moved keys only on the new conn, got %d -> %d", i, j)
+			}
+		}
+	}
+	if len(used) != 3 {
+		t.Fatalf("expect keys on every conn, got %v", used)
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("expect part of the keys moved, got %d", moved)
+	}
+}
+
+type shardItem struct {
+	ID int64 `gorm:"column:id;primary_key"`
+}
+
+func (shardItem) TableName() string {
+	return "shard_item"
+}
+
+func (shardItem) ConnectionName() string {
+	return "shard"
+}
+
+func (i shardItem) ShardKey() interface{} {
+	return i.ID
+}
+
+func TestGather(t *testing.T) {
+	conns := []string{"shard0", "shard1", "shard2"}
+	for _, c := range conns {
+		openTables(t, c, ConnConfig{}, []interface{}{&shardItem{}})
+	}
+	if err := RegisterShard("shard", ShardItem{Conns: conns}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shardsMu.Lock()
+		delete(shards, "shard")
+		shardsMu.Unlock()
+	})
+
+	for id := int64(1); id <= 9; id++ {
+		m := shardItem{ID: id}
+		if err := MustWriteModel(m).Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countShard(t, "shard1"); n != 3 {
+		t.Fatalf("expect rows spread by modulo, got %d", n)
+	}
+
+	rs := []shardItem{}
+	if err := Gather("shard", &rs, func(d *gorm.DB) *gorm.DB { return d.Order("id") }); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{3, 6, 9, 1, 4, 7, 2, 5, 8}
+	if fmt.Sprint(ids(rs)) != fmt.Sprint(want) {
+		t.Fatalf("expect results in shard order, got %v", ids(rs))
+	}
+	if err := Gather("shard", rs, nil); err != errGatherOut {
+		t.Fatalf("expect errGatherOut, got %v", err)
+	}
+}
+
+func countShard(t *testing.T, name string) int {
+	t.Helper()
+	n := 0
+	if err := MustWrite(name).Model(&shardItem{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func ids(rs []shardItem) []int64 {
+	r := make([]int64, 0, len(rs))
+	for _, i := range rs {
+		r = append(r, i.ID)
+	}
+	return r
+}
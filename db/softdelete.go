@@ -0,0 +1,131 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+软删除
+
+模型嵌入 SoftDelete 后:
+  查询, Count, Pluck, 更新自动加上 del_flag = 0
+  Delete 变为 UPDATE del_flag = 1
+  Unscoped() 跳过过滤, 并执行物理删除
+  Restore 恢复已删除的记录
+
+联表时使用 SoftDeleteJoin 给 join 的表加上同样的过滤
+
+*/
+
+const (
+	delFlagColumn = "del_flag"
+
+	softDeleteJoinKey = "db:soft_delete_join"
+)
+
+type SoftDelete struct {
+	DelFlag int8 `gorm:"column:del_flag;type:tinyint(4);not null;default:0"`
+}
+
+func (SoftDelete) softDelete() {}
+
+type softDeleter interface {
+	softDelete()
+}
+
+func isSoftDelete(scope *gorm.Scope) bool {
+	t := scope.GetModelStruct().ModelType
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := reflect.New(t).Interface().(softDeleter)
+	return ok
+}
+
+// softDeleteAlias 表名带别名时, 如 Table("user u"), 使用别名
+func softDeleteAlias(scope *gorm.Scope) string {
+	name := scope.QuotedTableName()
+	if div := strings.Fields(name); len(div) > 1 {
+		return div[len(div)-1]
+	}
+	return name
+}
+
+func softDeleteQuery(scope *gorm.Scope) {
+	if scope.HasError() || scope.Search.Unscoped {
+		return
+	}
+	if isSoftDelete(scope) {
+		scope.Search.Where(softDeleteAlias(scope)+"."+scope.Quote(delFlagColumn)+" = ?", 0)
+	}
+	if v, ok := scope.Get(softDeleteJoinKey); ok {
+		for _, prefix := range v.([]string) {
+			scope.Search.Where(prefix+"."+delFlagColumn+" = ?", 0)
+		}
+	}
+}
+
+func softDeleteCallback(hard func(*gorm.Scope)) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if scope.HasError() {
+			return
+		}
+		if scope.Search.Unscoped || !isSoftDelete(scope) {
+			hard(scope)
+			return
+		}
+		var extraOption string
+		if str, ok := scope.Get("gorm:delete_option"); ok {
+			extraOption = " " + fmt.Sprint(str)
+		}
+		cond := scope.CombinedConditionSql()
+		if cond != "" {
+			cond = " " + cond
+		}
+		scope.Raw(fmt.Sprintf(
+			"UPDATE %v SET %v = 1%v%v",
+			scope.QuotedTableName(),
+			scope.Quote(delFlagColumn),
+			cond,
+			extraOption,
+		)).Exec()
+	}
+}
+
+func registerSoftDelete(c *gorm.Callback) {
+	c.Query().Before("gorm:query").Register("my:soft_delete", softDeleteQuery)
+	c.RowQuery().Before("gorm:row_query").Register("my:soft_delete", softDeleteQuery)
+	c.Update().Before("gorm:update").Register("my:soft_delete", softDeleteQuery)
+	if hard := c.Delete().Get("gorm:delete"); hard != nil {
+		c.Delete().Replace("gorm:delete", softDeleteCallback(hard))
+	}
+}
+
+// SoftDeleteJoin 给 join 的表 (表名或别名) 加上 del_flag = 0, Unscoped 时跳过
+func SoftDeleteJoin(prefix ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if v, ok := db.Get(softDeleteJoinKey); ok {
+			prefix = append(append([]string{}, v.([]string)...), prefix...)
+		}
+		return db.Set(softDeleteJoinKey, prefix)
+	}
+}
+
+// OnlyDeleted 只查询已删除的记录
+func OnlyDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Where(delFlagColumn+" = ?", 1)
+}
+
+// Restore 恢复已删除的记录, value 带主键时只恢复该记录, 不检查乐观锁的 version
+func Restore(db *gorm.DB, value interface{}, where ...interface{}) *gorm.DB {
+	db = db.Unscoped().Set(versionSkipKey, true).Model(value)
+	if len(where) > 0 {
+		db = db.Where(where[0], where[1:]...)
+	}
+	return db.UpdateColumn(delFlagColumn, 0)
+}
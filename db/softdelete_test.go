@@ -0,0 +1,100 @@
+package db
+
+import (
+	"testing"
+)
+
+type sdItem struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name"`
+	SoftDelete
+}
+
+func (sdItem) TableName() string {
+	return "sd_item"
+}
+
+type sdTag struct {
+	ID     int64 `gorm:"column:id;primary_key"`
+	ItemID int64 `gorm:"column:item_id"`
+	SoftDelete
+}
+
+func (sdTag) TableName() string {
+	return "sd_tag"
+}
+
+// sdSeeds id 为 1 到 3 的行与各自的 tag
+func sdSeeds() []interface{} {
+	r := []interface{}{}
+	for i := int64(1); i <= 3; i++ {
+		r = append(r, &sdItem{ID: i, Name: "n"}, &sdTag{ID: i, ItemID: i})
+	}
+	return r
+}
+
+func TestSoftDelete(t *testing.T) {
+	d := openTables(t, "sd", ConnConfig{}, []interface{}{&sdItem{}, &sdTag{}}, sdSeeds()...).Write()
+
+	if err := d.Delete(&sdItem{ID: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	d.Model(&sdItem{}).Count(&n)
+	if n != 2 {
+		t.Fatalf("expect deleted row filtered, got %d", n)
+	}
+	ids := []int64{}
+	d.Model(&sdItem{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 2 || ids[0] != 2 {
+		t.Fatalf("expect deleted row not plucked, got %v", ids)
+	}
+	if d.Unscoped().Model(&sdItem{}).Count(&n); n != 3 {
+		t.Fatalf("expect Unscoped to see deleted row, got %d", n)
+	}
+
+	// 更新不影响已删除的记录
+	if r := d.Model(&sdItem{}).Where("id IN (?)", []int64{1, 2}).Update("name", "u"); r.RowsAffected != 1 {
+		t.Fatalf("expect 1 updated, got %d", r.RowsAffected)
+	}
+
+	rs := []sdItem{}
+	if OnlyDeleted(d).Find(&rs); len(rs) != 1 || rs[0].ID != 1 || rs[0].DelFlag != 1 || rs[0].Name != "n" {
+		t.Fatalf("expect only the deleted row, got %+v", rs)
+	}
+	if err := Restore(d, &sdItem{ID: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if d.Model(&sdItem{}).Count(&n); n != 3 {
+		t.Fatalf("expect row restored, got %d", n)
+	}
+
+	// Unscoped 时物理删除
+	d.Unscoped().Delete(&sdItem{ID: 3})
+	if d.Unscoped().Model(&sdItem{}).Count(&n); n != 2 {
+		t.Fatalf("expect row hard deleted, got %d", n)
+	}
+}
+
+func TestSoftDeleteJoin(t *testing.T) {
+	d := openTables(t, "sd_join", ConnConfig{}, []interface{}{&sdItem{}, &sdTag{}}, sdSeeds()...).Write()
+	d.Delete(&sdItem{ID: 1})
+	d.Delete(&sdTag{ID: 2})
+
+	rs := []sdItem{}
+	err := d.Table("sd_item i").Select("i.*").Joins("JOIN sd_tag t ON t.item_id = i.id").
+		Scopes(SoftDeleteJoin("t")).Order("i.id").Find(&rs).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].ID != 3 {
+		t.Fatalf("expect only the row with both sides alive, got %+v", rs)
+	}
+
+	rs = rs[:0]
+	d.Unscoped().Table("sd_item i").Select("i.*").Joins("JOIN sd_tag t ON t.item_id = i.id").
+		Scopes(SoftDeleteJoin("t")).Find(&rs)
+	if len(rs) != 3 {
+		t.Fatalf("expect Unscoped to skip join filters, got %+v", rs)
+	}
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// sqlite3 的驱动与方言需要 cgo, 由 db/sqlite 注册, 使用时引入该包
+const (
+	DriverSQLite = "sqlite3"
+)
+
+func driverName(driver string) string {
+	if driver == "sqlite" {
+		return DriverSQLite
+	}
+	return driver
+}
+
+// isMemory sqlite 内存库每个连接是独立的库
+func isMemory(driver, source string) bool {
+	return driver == DriverSQLite && (strings.Contains(source, ":memory:") || strings.Contains(source, "mode=memory"))
+}
+
+// setPool 内存库只保留一个永不过期的连接, 否则连接关闭后数据丢失
+// 因此内存库上事务进行中, 其他不在事务内的读写 (如 WithTx 中不带 ctx 的 Read/Write) 会一直等待连接
+// 需要并发访问时使用文件库, 或在事务中始终通过 ctx 取得连接
+func setPool(d *gorm.DB, driver, source string, c ConnConfig) {
+	if isMemory(driver, source) {
+		d.DB().SetConnMaxLifetime(0)
+		d.DB().SetMaxIdleConns(1)
+		d.DB().SetMaxOpenConns(1)
+		return
+	}
+	d.DB().SetConnMaxLifetime(time.Duration(c.ConnMaxLifeTime) * time.Second)
+	d.DB().SetMaxIdleConns(c.MaxIdleConns)
+	d.DB().SetMaxOpenConns(c.MaxOpenConns)
+}
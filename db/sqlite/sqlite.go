@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"reflect"
+	"regexp"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+/**
+
+sqlite3 驱动, 需要 cgo, 只在引入时注册
+
+  import _ "github.com/joetang09/goengineer/db/sqlite"
+
+引入后 db.ConnConfig 的 Driver 可以使用 sqlite3
+
+*/
+
+const (
+	driver = "sqlite3"
+)
+
+var (
+	proto gorm.Dialect
+
+	unsignedReg = regexp.MustCompile(`(?i)\s+unsigned`)
+)
+
+func init() {
+	if d, ok := gorm.GetDialect(driver); ok {
+		proto = d
+		gorm.RegisterDialect(driver, &dialect{})
+	}
+}
+
+// dialect 去掉 mysql 的 unsigned, 使按 mysql 写的 type 标签可以在 sqlite 上建表
+type dialect struct {
+	gorm.Dialect
+}
+
+func (d *dialect) SetDB(db gorm.SQLCommon) {
+	d.Dialect = reflect.New(reflect.TypeOf(proto).Elem()).Interface().(gorm.Dialect)
+	d.Dialect.SetDB(db)
+}
+
+func (d *dialect) DataTypeOf(field *gorm.StructField) string {
+	return unsignedReg.ReplaceAllString(d.Dialect.DataTypeOf(field), "")
+}
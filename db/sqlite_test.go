@@ -0,0 +1,55 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/joetang09/goengineer/db/sqlite"
+)
+
+type sqliteItem struct {
+	ID    int64  `gorm:"column:id;type:bigint(20) unsigned;primary_key"`
+	Name  string `gorm:"column:name;type:varchar(64);not null;default:''"`
+	Count int    `gorm:"column:count;type:int(10) unsigned;not null;default:0"`
+}
+
+func (sqliteItem) TableName() string {
+	return "lite_item"
+}
+
+func TestSQLiteMemory(t *testing.T) {
+	w := openTest(t, "sqlite_mem", ConnConfig{Driver: "sqlite", MaxOpenConns: 4})
+	if n := w.Write().DB().Stats().MaxOpenConnections; n != 1 {
+		t.Fatalf("expect memory db kept on one connection, got %d", n)
+	}
+
+	// mysql 的 unsigned 类型可以在 sqlite 上建表
+	d := w.Write()
+	if err := d.CreateTable(&sqliteItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&sqliteItem{ID: 1, Name: "a", Count: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	i := sqliteItem{}
+	if err := w.Read().First(&i, 1).Error; err != nil || i.Name != "a" || i.Count != 2 {
+		t.Fatalf("expect row read back, got %+v %v", i, err)
+	}
+}
+
+func TestSQLiteFile(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.db")
+	w := openTest(t, "sqlite_file", ConnConfig{Source: f, MaxOpenConns: 4, Slave: []SlaveConfig{{Source: f}}})
+	if n := w.Write().DB().Stats().MaxOpenConnections; n != 4 {
+		t.Fatalf("expect pool config applied, got %d", n)
+	}
+
+	if err := w.Write().CreateTable(&sqliteItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	w.Write().Create(&sqliteItem{ID: 1, Name: "a"})
+	n := 0
+	if err := w.Slaves()[0].DB().Model(&sqliteItem{}).Count(&n).Error; err != nil || n != 1 {
+		t.Fatalf("expect the slave to read the same file, got %d %v", n, err)
+	}
+}
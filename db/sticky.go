@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultStickyWindow = 5 * time.Second
+
+	stickyCookieName = "db-sticky"
+)
+
+type stickyKey struct{}
+
+// sticky 记录 context 中每个连接最近一次写之后读走 master 的截止时间
+type sticky struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func WithSticky(ctx context.Context) context.Context {
+	if stickyFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stickyKey{}, &sticky{until: map[string]time.Time{}})
+}
+
+func stickyFrom(ctx context.Context) *sticky {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(stickyKey{}).(*sticky)
+	return s
+}
+
+func (s *sticky) mark(name string, d time.Duration) {
+	s.markUntil(name, time.Now().Add(d))
+}
+
+func (s *sticky) markUntil(name string, u time.Time) {
+	s.mu.Lock()
+	if u.After(s.until[name]) {
+		s.until[name] = u
+	}
+	s.mu.Unlock()
+}
+
+func (s *sticky) active(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.until[name]
+	if !ok {
+		return false
+	}
+	if time.Now().After(u) {
+		delete(s.until, name)
+		return false
+	}
+	return true
+}
+
+// encode 编码为 name:unix毫秒, 供 cookie 使用
+func (s *sticky) encode() (string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	r := []string{}
+	max := time.Duration(0)
+	for n, u := range s.until {
+		if d := u.Sub(now); d > 0 {
+			r = append(r, n+":"+strconv.FormatInt(u.UnixNano()/int64(time.Millisecond), 10))
+			if d > max {
+				max = d
+			}
+		}
+	}
+	return strings.Join(r, ","), max
+}
+
+// decode 截止时间最多到 now + 窗口期, 避免伪造的 cookie 长期把读固定在 master
+func (s *sticky) decode(v string) {
+	now := time.Now()
+	for _, item := range strings.Split(v, ",") {
+		div := strings.SplitN(item, ":", 2)
+		if len(div) != 2 {
+			continue
+		}
+		ms, err := strconv.ParseInt(div[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		w, e := get(div[0])
+		if e != nil {
+			continue
+		}
+		u := time.Unix(0, ms*int64(time.Millisecond))
+		if max := now.Add(w.window()); u.After(max) {
+			u = max
+		}
+		s.markUntil(div[0], u)
+	}
+}
+
+func (db *Wrapper) window() time.Duration {
+	if db.stickyWindow > 0 {
+		return db.stickyWindow
+	}
+	return defaultStickyWindow
+}
+
+// ReadContext 在 ctx 中写过 name 之后的窗口期内读 master, ctx 中有事务时使用事务
+func ReadContext(ctx context.Context, name string) (*gorm.DB, error) {
+	if tx, ok := TxFrom(ctx, name); ok {
+		return tx, nil
+	}
+	w, e := get(name)
+	if e != nil {
+		return nil, e
+	}
+	if s := stickyFrom(ctx); s != nil && s.active(name) {
+		return withContext(ctx, w.Write()), nil
+	}
+	return withContext(ctx, w.Read()), nil
+}
+
+// WriteContext 写 master, 并使 ctx 中之后的读在窗口期内走 master
+func WriteContext(ctx context.Context, name string) (*gorm.DB, error) {
+	if tx, ok := TxFrom(ctx, name); ok {
+		return tx, nil
+	}
+	w, e := get(name)
+	if e != nil {
+		return nil, e
+	}
+	if s := stickyFrom(ctx); s != nil {
+		s.mark(name, w.window())
+	}
+	return withContext(ctx, w.Write()), nil
+}
+
+func MustReadContext(ctx context.Context, name string) *gorm.DB {
+	r, e := ReadContext(ctx, name)
+	if e != nil {
+		panic(e)
+	}
+	return r
+}
+
+func MustWriteContext(ctx context.Context, name string) *gorm.DB {
+	r, e := WriteContext(ctx, name)
+	if e != nil {
+		panic(e)
+	}
+	return r
+}
+
+// ReadPrimary 明确需要最新数据的读
+func ReadPrimary(name string) (*gorm.DB, error) {
+	return Write(name)
+}
+
+func MustReadPrimary(name string) *gorm.DB {
+	return MustWrite(name)
+}
+
+// StickyMiddleware 将 sticky 放入请求的 context, 并通过 cookie 在同一会话的后续请求中保持
+func StickyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := WithSticky(c.Request.Context())
+		s := stickyFrom(ctx)
+
+		if cookie, err := c.Request.Cookie(stickyCookieName); err == nil {
+			s.decode(cookie.Value)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		// cookie 需要在写 body 之前设置
+		c.Writer = &stickyWriter{ResponseWriter: c.Writer, s: s}
+		c.Next()
+	}
+}
+
+type stickyWriter struct {
+	gin.ResponseWriter
+	s       *sticky
+	written bool
+}
+
+func (w *stickyWriter) setCookie() {
+	if w.written {
+		return
+	}
+	w.written = true
+	v, d := w.s.encode()
+	if v == "" {
+		return
+	}
+	http.SetCookie(w.ResponseWriter, &http.Cookie{
+		Name:     stickyCookieName,
+		Value:    v,
+		Path:     "/",
+		MaxAge:   int(d/time.Second) + 1,
+		HttpOnly: true,
+	})
+}
+
+func (w *stickyWriter) WriteHeader(code int) {
+	w.setCookie()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *stickyWriter) WriteHeaderNow() {
+	w.setCookie()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *stickyWriter) Write(b []byte) (int, error) {
+	w.setCookie()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *stickyWriter) WriteString(s string) (int, error) {
+	w.setCookie()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStickyContext(t *testing.T) {
+	w := openTest(t, "sticky", ConnConfig{Slave: []SlaveConfig{{Source: ":memory:"}}})
+	ctx := WithSticky(context.Background())
+
+	if d := MustReadContext(ctx, "sticky"); d.CommonDB() != w.Read().CommonDB() {
+		t.Fatal("expect reads on slave before write")
+	}
+	MustWriteContext(ctx, "sticky")
+	if d := MustReadContext(ctx, "sticky"); d.CommonDB() != w.Write().CommonDB() {
+		t.Fatal("expect reads on master after write")
+	}
+	if d := MustReadContext(context.Background(), "sticky"); d.CommonDB() != w.Read().CommonDB() {
+		t.Fatal("expect other contexts not sticky")
+	}
+}
+
+func TestStickyDecodeClamp(t *testing.T) {
+	openTest(t, "sticky_clamp", ConnConfig{StickyWindow: 2})
+	s := &sticky{until: map[string]time.Time{}}
+
+	far := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	s.decode("sticky_clamp:" + strconv.FormatInt(far, 10) + ",unknown:" + strconv.FormatInt(far, 10) + ",bad")
+	if _, ok := s.until["unknown"]; ok || len(s.until) != 1 {
+		t.Fatalf("expect only registered connections decoded, got %v", s.until)
+	}
+	if d := time.Until(s.until["sticky_clamp"]); d > 2*time.Second {
+		t.Fatalf("expect expiry clamped to the window, got %v", d)
+	}
+}
+
+func TestStickyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	openTest(t, "sticky_mw", ConnConfig{})
+	e := gin.New()
+	e.Use(StickyMiddleware())
+	e.GET("/write", func(c *gin.Context) {
+		MustWriteContext(c.Request.Context(), "sticky_mw")
+		c.String(http.StatusOK, "ok")
+	})
+	e.GET("/read", func(c *gin.Context) {
+		c.String(http.StatusOK, strconv.FormatBool(stickyFrom(c.Request.Context()).active("sticky_mw")))
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/write", nil))
+	cs := rec.Result().Cookies()
+	if len(cs) != 1 || cs[0].Name != stickyCookieName {
+		t.Fatalf("expect sticky cookie, got %v", cs)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/read", nil)
+	req.AddCookie(cs[0])
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Body.String() != "true" {
+		t.Fatal("expect the next request sticky")
+	}
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/joetang09/goengineer/engineer"
+)
+
+const (
+	traceStartKey = "db:trace_start"
+)
+
+var (
+	dbLogger = engineer.GetLogger("db")
+
+	tracer   Tracer
+	tracerMu sync.RWMutex
+)
+
+type QueryInfo struct {
+	Conn      string
+	Slave     bool
+	SQL       string
+	Vars      []interface{} // nil if RedactSQL
+	Start     time.Time
+	Duration  time.Duration
+	Rows      int64
+	Err       error
+	RequestID string // engineer.RequestID of the ctx given to ReadContext/WriteContext/WithTx, set by webserver.RequestIDMiddleware
+}
+
+// Tracer 接收抽样的查询, ctx 为 ReadContext/WriteContext/WithTx 传入的 context
+type Tracer interface {
+	Trace(ctx context.Context, q QueryInfo)
+}
+
+func SetTracer(t Tracer) {
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+}
+
+func getTracer() Tracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+func (c callbackConfig) sampled() bool {
+	return c.sampling >= 1 || (c.sampling > 0 && rander.Float64() < c.sampling)
+}
+
+func (q QueryInfo) role() string {
+	if q.Slave {
+		return "slave"
+	}
+	return "master"
+}
+
+func traceStart(scope *gorm.Scope) {
+	scope.InstanceSet(traceStartKey, time.Now())
+}
+
+// traceEnd 记录执行 SQL 的耗时; 没有执行 SQL (如命中缓存) 时不记录
+func traceEnd(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(traceStartKey)
+	if !ok || scope.SQL == "" {
+		return
+	}
+	start := v.(time.Time)
+	c := scopeCallbackConfig(scope)
+	t := getTracer()
+	duration := time.Since(start)
+	slow := c.slow > 0 && duration >= c.slow
+	if !slow && (t == nil || !c.sampled()) {
+		return
+	}
+
+	ctx := scopeContext(scope)
+	q := QueryInfo{
+		Conn:      c.conn,
+		Slave:     c.slave,
+		SQL:       scope.SQL,
+		Start:     start,
+		Duration:  duration,
+		Rows:      scope.DB().RowsAffected,
+		RequestID: engineer.RequestID(ctx),
+	}
+	if !c.redact {
+		q.Vars = scope.SQLVars
+	}
+	if err := scope.DB().Error; err != nil && err != gorm.ErrRecordNotFound {
+		q.Err = err
+	}
+	if slow {
+		dbLogger.Warnf("slow query [%s %s] %v rows:%d request:%s : %s %v", q.Conn, q.role(), q.Duration, q.Rows, q.RequestID, q.SQL, q.Vars)
+	}
+	if t != nil {
+		t.Trace(ctx, q)
+	}
+}
+
+// registerTrace 在执行 SQL 的回调前后计时, 不替换 gorm 的回调
+// CallbackProcessor 注册后不能复用, 每次注册重新取得
+func registerTrace(c *gorm.Callback) {
+	for _, p := range []struct {
+		cp   func() *gorm.CallbackProcessor
+		name string
+	}{
+		{c.Create, "gorm:create"},
+		{c.Update, "gorm:update"},
+		{c.Delete, "gorm:delete"},
+		{c.Query, "gorm:query"},
+		{c.RowQuery, "gorm:row_query"},
+	} {
+		p.cp().Before(p.name).Register("my:trace_start", traceStart)
+		p.cp().After(p.name).Register("my:trace", traceEnd)
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/engineer"
+)
+
+type testTracer struct {
+	mu sync.Mutex
+	qs []QueryInfo
+}
+
+func (t *testTracer) Trace(ctx context.Context, q QueryInfo) {
+	t.mu.Lock()
+	t.qs = append(t.qs, q)
+	t.mu.Unlock()
+}
+
+func (t *testTracer) take() []QueryInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.qs
+	t.qs = nil
+	return r
+}
+
+func setTestTracer(t *testing.T) *testTracer {
+	tr := &testTracer{}
+	SetTracer(tr)
+	t.Cleanup(func() { SetTracer(nil) })
+	return tr
+}
+
+func TestTraceSampling(t *testing.T) {
+	d := openItems(t, "trace", ConnConfig{TraceSampling: 1}).Write()
+	tr := setTestTracer(t)
+
+	ctx := engineer.WithRequestID(context.Background(), "req-1")
+	MustWriteContext(ctx, "trace").Create(&testItem{ID: 1, Name: "secret"})
+	qs := tr.take()
+	if len(qs) != 1 || qs[0].Conn != "trace" || qs[0].Slave || qs[0].Rows != 1 || qs[0].RequestID != "req-1" {
+		t.Fatalf("expect the insert traced with request id, got %+v", qs)
+	}
+	if len(qs[0].Vars) == 0 || qs[0].Duration <= 0 {
+		t.Fatalf("expect vars and duration recorded, got %+v", qs[0])
+	}
+
+	// 命中缓存时没有执行 SQL, 不记录
+	d.Scopes(Cache(time.Minute)).First(&testItem{}, 1)
+	d.Scopes(Cache(time.Minute)).First(&testItem{}, 1)
+	if qs := tr.take(); len(qs) != 1 {
+		t.Fatalf("expect only the uncached query traced, got %+v", qs)
+	}
+
+	d2 := openItems(t, "trace_off", ConnConfig{}).Write()
+	d2.Create(&testItem{ID: 1})
+	if qs := tr.take(); len(qs) != 0 {
+		t.Fatalf("expect nothing traced without sampling, got %+v", qs)
+	}
+}
+
+func TestTraceRedact(t *testing.T) {
+	d := openItems(t, "trace_redact", ConnConfig{TraceSampling: 1, RedactSQL: true}).Write()
+	tr := setTestTracer(t)
+
+	d.Where("name = ?", "secret").Find(&[]testItem{})
+	qs := tr.take()
+	if len(qs) != 1 || qs[0].Vars != nil {
+		t.Fatalf("expect vars redacted, got %+v", qs)
+	}
+}
+
+func TestTraceSlow(t *testing.T) {
+	d := openTest(t, "trace_slow", ConnConfig{SlowQuery: 1}).Write()
+	tr := setTestTracer(t)
+
+	// 没有抽样时只记录慢查询
+	d.Exec("SELECT 1")
+	r := struct{ N int }{}
+	d.Raw("WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000) SELECT count(*) AS n FROM c").Scan(&r)
+	qs := tr.take()
+	if len(qs) != 1 || qs[0].Duration < time.Millisecond {
+		t.Fatalf("expect only the slow query traced, got %+v", qs)
+	}
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"runtime/debug"
 
@@ -17,30 +19,52 @@ const (
 type TransError struct {
 	Code int
 	Msg  string
+	Err  error
 }
 
 func (t TransError) Error() string {
 	return fmt.Sprintf(transErrF, t.Code, t.Msg)
 }
 
+func (t TransError) Unwrap() error {
+	return t.Err
+}
+
+func newTransError(code int, err error) *TransError {
+	return &TransError{code, err.Error(), err}
+}
+
 type Task func(db *gorm.DB) error
 
 func ErrHandler(db *gorm.DB, task Task) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			msg := fmt.Sprintf("panic: %s\ncalltrace : %s", fmt.Sprint(e), string(debug.Stack()))
-			err = &TransError{intErrCode, msg}
+			err = &TransError{intErrCode, msg, nil}
 		}
 	}()
 	return task(db)
 }
 
+// ExecTrans db 已在事务中时使用 savepoint, 失败只回滚到 savepoint
 func ExecTrans(db *gorm.DB, trans ...Task) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		t := &txState{db: db, driver: db.Dialect().GetName()}
+		return t.nested(context.Background(), func(context.Context) error {
+			for _, task := range trans {
+				if err := ErrHandler(db, task); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
 	execDb := db.Begin()
 	if execDb.Error != nil {
 		fmt.Printf("DB begin transaction failed: %s", execDb.Error.Error())
-		return &TransError{dbErrCode, execDb.Error.Error()}
+		return newTransError(dbErrCode, execDb.Error)
 	}
+	execDb, ct := withCacheTx(execDb)
 	for _, task := range trans {
 		if err := ErrHandler(execDb, task); err != nil {
 			if err := execDb.Rollback().Error; err != nil {
@@ -52,7 +76,8 @@ func ExecTrans(db *gorm.DB, trans ...Task) error {
 
 	if err := execDb.Commit().Error; err != nil {
 		execDb.Rollback()
-		return &TransError{dbErrCode, err.Error()}
+		return newTransError(dbErrCode, err)
 	}
+	ct.invalidate()
 	return nil
 }
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+)
+
+type txKey struct {
+	name string
+}
+
+// txState context 中的事务, 嵌套调用通过 savepoint 实现
+// 事务只有一个连接, 不支持在多个 goroutine 中并发使用同一个 ctx 的事务; seq 只保证 savepoint 名字不重复
+type txState struct {
+	db     *gorm.DB
+	driver string
+	seq    int32
+}
+
+func txFrom(ctx context.Context, name string) *txState {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(txKey{name}).(*txState)
+	return t
+}
+
+// TxFrom 返回 ctx 中 name 连接上正在进行的事务
+func TxFrom(ctx context.Context, name string) (*gorm.DB, bool) {
+	t := txFrom(ctx, name)
+	if t == nil {
+		return nil, false
+	}
+	return t.db, true
+}
+
+// WithTx 在事务中执行 f, f 中通过 ctx 使用的 name 连接都在同一个事务内
+// 嵌套调用使用 savepoint, 内层失败只回滚到 savepoint; ctx 结束时事务被回滚
+// f 中不要把 ctx 交给并发的 goroutine 使用 name 连接, 事务内的语句需要顺序执行
+func WithTx(ctx context.Context, name string, f func(context.Context) error) error {
+	return withTx(ctx, name, nil, f)
+}
+
+// withTx r 为空时使用连接配置的重试
+func withTx(ctx context.Context, name string, r *Retry, f func(context.Context) error) error {
+	if t := txFrom(ctx, name); t != nil {
+		return t.nested(ctx, f)
+	}
+
+	w, err := get(name)
+	if err != nil {
+		return err
+	}
+	if p := w.pin(); p != nil {
+		t := &txState{db: withContext(ctx, p), driver: w.driver}
+		return t.nested(context.WithValue(ctx, txKey{name}, t), f)
+	}
+	if s := stickyFrom(ctx); s != nil {
+		s.mark(name, w.window())
+	}
+	if r == nil {
+		r = &w.retry
+	}
+	if r.Attempts > 1 {
+		return r.do(ctx, func() error {
+			return w.beginTx(ctx, name, f)
+		})
+	}
+	return w.beginTx(ctx, name, f)
+}
+
+// pinConn 使 name 连接的 Read/Write 都返回 d, 返回的函数恢复之前的连接, 通过 dbhook.Pin 供 dbtest 使用
+// 用于测试中将连接固定到不提交的事务, 此时 WithTx 与 ExecTrans 使用 savepoint; 固定期间所有使用者共享 d
+// 固定期间写入的表在恢复时失效查询缓存
+func pinConn(name string, d *gorm.DB) (func(), error) {
+	w, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+	d, ct := withCacheTx(d)
+	w.mu.Lock()
+	old := w.pinned
+	w.pinned = d
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		w.pinned = old
+		w.mu.Unlock()
+		ct.invalidate()
+	}, nil
+}
+
+func (w *Wrapper) beginTx(ctx context.Context, name string, f func(context.Context) error) error {
+	tx := w.Write().BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return newTransError(dbErrCode, tx.Error)
+	}
+	tx, ct := withCacheTx(tx)
+	t := &txState{db: withContext(ctx, tx), driver: w.driver}
+
+	if err := runTx(context.WithValue(ctx, txKey{name}, t), f); err != nil {
+		if e := tx.Rollback().Error; e != nil {
+			fmt.Printf("roll_back : %s\n", e)
+		}
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return newTransError(dbErrCode, err)
+	}
+	ct.invalidate()
+	return nil
+}
+
+func runTx(ctx context.Context, f func(context.Context) error) error {
+	return ErrHandler(nil, func(*gorm.DB) error {
+		return f(ctx)
+	})
+}
+
+func (t *txState) nested(ctx context.Context, f func(context.Context) error) error {
+	sp := fmt.Sprintf("sp_%d", atomic.AddInt32(&t.seq, 1))
+
+	if err := t.db.Exec(t.savepoint(sp)).Error; err != nil {
+		return newTransError(dbErrCode, err)
+	}
+	if err := runTx(ctx, f); err != nil {
+		if e := t.db.Exec(t.rollbackTo(sp)).Error; e != nil {
+			return newTransError(dbErrCode, e)
+		}
+		return err
+	}
+	if q := t.release(sp); q != "" {
+		if err := t.db.Exec(q).Error; err != nil {
+			return newTransError(dbErrCode, err)
+		}
+	}
+	return nil
+}
+
+func (t *txState) savepoint(sp string) string {
+	if t.driver == "mssql" {
+		return "SAVE TRANSACTION " + sp
+	}
+	return "SAVEPOINT " + sp
+}
+
+func (t *txState) rollbackTo(sp string) string {
+	if t.driver == "mssql" {
+		return "ROLLBACK TRANSACTION " + sp
+	}
+	return "ROLLBACK TO SAVEPOINT " + sp
+}
+
+func (t *txState) release(sp string) string {
+	if t.driver == "mssql" {
+		return ""
+	}
+	return "RELEASE SAVEPOINT " + sp
+}
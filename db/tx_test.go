@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errTest = errors.New("Test Error")
+
+func createItem(ctx context.Context, name string, i int64) error {
+	return MustWriteContext(ctx, name).Create(&testItem{ID: i}).Error
+}
+
+func TestWithTx(t *testing.T) {
+	w := openItems(t, "tx", ConnConfig{})
+	ctx := context.Background()
+
+	if err := WithTx(ctx, "tx", func(ctx context.Context) error {
+		return createItem(ctx, "tx", 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithTx(ctx, "tx", func(ctx context.Context) error {
+		createItem(ctx, "tx", 2)
+		return errTest
+	}); err != errTest {
+		t.Fatalf("expect errTest, got %v", err)
+	}
+	if n := countItems(t, w.Write()); n != 1 {
+		t.Fatalf("expect only the committed row, got %d", n)
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	w := openItems(t, "tx_sp", ConnConfig{})
+
+	err := WithTx(context.Background(), "tx_sp", func(ctx context.Context) error {
+		createItem(ctx, "tx_sp", 1)
+		// 内层失败只回滚到 savepoint
+		if err := WithTx(ctx, "tx_sp", func(ctx context.Context) error {
+			createItem(ctx, "tx_sp", 2)
+			return errTest
+		}); err != errTest {
+			t.Fatalf("expect errTest, got %v", err)
+		}
+		return WithTx(ctx, "tx_sp", func(ctx context.Context) error {
+			return createItem(ctx, "tx_sp", 3)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	w.Write().Model(&testItem{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Fatalf("expect rows 1 and 3, got %v", ids)
+	}
+}
+
+func TestWithTxPinned(t *testing.T) {
+	w := openItems(t, "tx_pin", ConnConfig{})
+	tx := w.Write().Begin()
+	restore, err := pinConn("tx_pin", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WithTx(context.Background(), "tx_pin", func(ctx context.Context) error {
+		return createItem(ctx, "tx_pin", 1)
+	})
+	if n := countItems(t, tx); n != 1 {
+		t.Fatalf("expect the row in the pinned tx, got %d", n)
+	}
+	restore()
+	tx.Rollback()
+	if n := countItems(t, w.Write()); n != 0 {
+		t.Fatalf("expect the pinned tx rolled back, got %d", n)
+	}
+}
+
+func TestTransError(t *testing.T) {
+	err := WithTx(context.Background(), "tx_unknown", func(context.Context) error { return nil })
+	if err != errDBNotFound {
+		t.Fatalf("expect errDBNotFound, got %v", err)
+	}
+
+	// panic 被转为 TransError
+	openItems(t, "tx_err", ConnConfig{})
+	err = WithTx(context.Background(), "tx_err", func(context.Context) error { panic("boom") })
+	var te *TransError
+	if !errors.As(err, &te) || te.Code != intErrCode || te.Err != nil {
+		t.Fatalf("expect panic TransError, got %v", err)
+	}
+
+	// 开启事务失败时可以取出原始错误
+	w := openItems(t, "tx_closed", ConnConfig{})
+	w.Write().Close()
+	err = WithTx(context.Background(), "tx_closed", func(context.Context) error { return nil })
+	if !errors.As(err, &te) || te.Code != dbErrCode || te.Err == nil || errors.Unwrap(err) != te.Err {
+		t.Fatalf("expect db TransError wrapping the cause, got %#v", err)
+	}
+}
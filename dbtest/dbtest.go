@@ -0,0 +1,253 @@
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+
+	"github.com/joetang09/goengineer/db"
+	_ "github.com/joetang09/goengineer/db/sqlite"
+	"github.com/joetang09/goengineer/internal/dbhook"
+)
+
+/**
+
+测试数据库
+
+  func TestUser(t *testing.T) {
+      d := dbtest.Open(t, "main", db.ConnConfig{})
+      d.AutoMigrate(&User{})
+      d.Fixtures("testdata/users.yaml").Begin(t)
+
+      db.MustWrite("main").Create(&User{Name: "bob"}) // 在 Begin 的事务中, 测试结束时回滚
+  }
+
+多个测试共享一个连接时在 TestMain 中使用 Setup, 每个测试调用 Begin
+Begin 固定的连接所有使用者共享, 使用同一个连接的测试不能并行
+
+*/
+
+const (
+	Memory = ":memory:"
+)
+
+var (
+	errFixture = errors.New("Fixture Error")
+)
+
+type DB struct {
+	Name     string
+	fixtures []string
+}
+
+// Setup 注册名为 name 的连接, Driver 为空时使用 sqlite, Source 为空时使用内存库
+func Setup(name string, c db.ConnConfig) (*DB, error) {
+	if c.Driver == "" {
+		c.Driver = db.DriverSQLite
+	}
+	if c.Source == "" && c.Driver == db.DriverSQLite {
+		c.Source = Memory
+	}
+	if err := db.Register(name, c); err != nil {
+		return nil, err
+	}
+	return &DB{Name: name}, nil
+}
+
+// Open 与 Setup 相同, 测试结束时移除连接
+func Open(tb testing.TB, name string, c db.ConnConfig) *DB {
+	tb.Helper()
+	d, err := Setup(name, c)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := d.Close(); err != nil {
+			tb.Error(err)
+		}
+	})
+	return d
+}
+
+func (d *DB) Close() error {
+	return db.Remove(d.Name)
+}
+
+func (d *DB) AutoMigrate(models ...interface{}) error {
+	w, err := db.Write(d.Name)
+	if err != nil {
+		return err
+	}
+	return w.Scopes(db.TableOptions).AutoMigrate(models...).Error
+}
+
+// Migrate 执行连接的 MigrationDir 与注册的迁移, 需要在 Begin 之前调用
+func (d *DB) Migrate() (int, error) {
+	m, err := db.NewMigrator(d.Name)
+	if err != nil {
+		return 0, err
+	}
+	return m.Up()
+}
+
+// Fixtures 设置每次 Begin 时加载的数据文件
+func (d *DB) Fixtures(files ...string) *DB {
+	d.fixtures = append(d.fixtures, files...)
+	return d
+}
+
+// Load 将数据文件加载到当前连接, 在 Begin 之后调用时加载到事务中
+func (d *DB) Load(files ...string) error {
+	w, err := db.Write(d.Name)
+	if err != nil {
+		return err
+	}
+	tables, err := load(w, files...)
+	if e := dbhook.Invalidate(d.Name, tables...); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Begin 开始事务并将连接的 Read/Write 固定到该事务, 加载 Fixtures
+// 测试结束时回滚事务并失效事务中写入的表的查询缓存, 下一个测试看到的是 Begin 之前的数据
+func (d *DB) Begin(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	w, err := db.Write(d.Name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tx := w.Begin()
+	if tx.Error != nil {
+		tb.Fatal(tx.Error)
+	}
+	restore, err := dbhook.Pin(d.Name, tx)
+	if err != nil {
+		tx.Rollback()
+		tb.Fatal(err)
+	}
+	var tables []string
+	tb.Cleanup(func() {
+		restore()
+		if err := tx.Rollback().Error; err != nil {
+			tb.Error(err)
+		}
+		// Fixtures 不经过回调, 单独失效
+		if err := dbhook.Invalidate(d.Name, tables...); err != nil {
+			tb.Error(err)
+		}
+	})
+	if tables, err = load(tx, d.fixtures...); err != nil {
+		tb.Fatal(err)
+	}
+	return tx
+}
+
+// Truncate 删除表中所有数据, 用于不在 Begin 的事务中执行的测试
+func (d *DB) Truncate(tables ...string) error {
+	w, err := db.Write(d.Name)
+	if err != nil {
+		return err
+	}
+	for i, t := range tables {
+		if err := w.Exec("DELETE FROM " + w.Dialect().Quote(t)).Error; err != nil {
+			dbhook.Invalidate(d.Name, tables[:i]...)
+			return err
+		}
+	}
+	return dbhook.Invalidate(d.Name, tables...)
+}
+
+// Load 按顺序加载 yaml, json 或 toml 格式的数据文件, 每个表对应一组行:
+//
+//	users:
+//	  - id: 1
+//	    name: bob
+//
+// 数据直接插入, 不经过回调; 同一文件中的表按表名顺序插入, 有外键依赖时分成多个文件
+// 表名与列名按小写处理
+func Load(g *gorm.DB, files ...string) error {
+	_, err := load(g, files...)
+	return err
+}
+
+// load 返回写入过的表, 出错时包含出错之前的表
+func load(g *gorm.DB, files ...string) ([]string, error) {
+	loaded := []string{}
+	for _, f := range files {
+		v := viper.New()
+		v.SetConfigFile(f)
+		if err := v.ReadInConfig(); err != nil {
+			return loaded, err
+		}
+		tables := v.AllSettings()
+		names := make([]string, 0, len(tables))
+		for t := range tables {
+			names = append(names, t)
+		}
+		sort.Strings(names)
+
+		for _, t := range names {
+			rows, ok := tables[t].([]interface{})
+			if !ok {
+				return loaded, fmt.Errorf("%w : %s %s is not a list", errFixture, f, t)
+			}
+			loaded = append(loaded, t)
+			for i, r := range rows {
+				row, ok := toMap(r)
+				if !ok {
+					return loaded, fmt.Errorf("%w : %s %s[%d] is not a map", errFixture, f, t, i)
+				}
+				if err := insert(g, t, row); err != nil {
+					return loaded, fmt.Errorf("load %s %s[%d] : %w", f, t, i, err)
+				}
+			}
+		}
+	}
+	return loaded, nil
+}
+
+func insert(g *gorm.DB, table string, row map[string]interface{}) error {
+	if len(row) == 0 {
+		return nil
+	}
+	cols := make([]string, 0, len(row))
+	for c := range row {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+
+	quoted := make([]string, len(cols))
+	vars := make([]interface{}, len(cols))
+	for i, c := range cols {
+		quoted[i] = g.Dialect().Quote(c)
+		vars[i] = row[c]
+	}
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", g.Dialect().Quote(table), strings.Join(quoted, ","),
+		strings.TrimSuffix(strings.Repeat("?,", len(cols)), ","))
+	return g.Exec(sql, vars...).Error
+}
+
+// toMap yaml 解析出的行可能是 map[interface{}]interface{}, 列名统一转为小写
+func toMap(i interface{}) (map[string]interface{}, bool) {
+	switch m := i.(type) {
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			r[strings.ToLower(k)] = v
+		}
+		return r, true
+	case map[interface{}]interface{}:
+		r := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			r[strings.ToLower(fmt.Sprint(k))] = v
+		}
+		return r, true
+	}
+	return nil, false
+}
@@ -0,0 +1,162 @@
+package dbtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joetang09/goengineer/db"
+)
+
+type user struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name;type:varchar(64)"`
+}
+
+func (user) TableName() string {
+	return "users"
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func openUsers(t *testing.T, name string) *DB {
+	t.Helper()
+	d := Open(t, name, db.ConnConfig{})
+	if err := d.AutoMigrate(&user{}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func users(t *testing.T, name string, cache bool) []user {
+	t.Helper()
+	r := []user{}
+	q := db.MustRead(name)
+	if cache {
+		q = q.Scopes(db.Cache(time.Minute))
+	}
+	if err := q.Order("id").Find(&r).Error; err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMemory(t *testing.T) {
+	openUsers(t, "dbtest_mem_a")
+	Open(t, "dbtest_mem_b", db.ConnConfig{})
+
+	db.MustWrite("dbtest_mem_a").Create(&user{ID: 1, Name: "bob"})
+	if us := users(t, "dbtest_mem_a", false); len(us) != 1 || us[0].Name != "bob" {
+		t.Fatalf("expect the row read back, got %+v", us)
+	}
+	// 每个连接是独立的内存库
+	if db.MustWrite("dbtest_mem_b").HasTable("users") {
+		t.Fatal("expect memory databases not shared")
+	}
+}
+
+func TestBeginRollback(t *testing.T) {
+	f := writeFile(t, "users.yaml", `
+users:
+  - id: 1
+    name: bob
+  - id: 2
+    name: alice
+`)
+	d := openUsers(t, "dbtest_begin").Fixtures(f)
+
+	t.Run("tx", func(t *testing.T) {
+		d.Begin(t)
+		if us := users(t, "dbtest_begin", false); len(us) != 2 {
+			t.Fatalf("expect fixtures loaded, got %+v", us)
+		}
+		db.MustWrite("dbtest_begin").Create(&user{ID: 3, Name: "carol"})
+		// Read 也固定到事务, WithTx 使用 savepoint
+		err := db.WithTx(context.Background(), "dbtest_begin", func(ctx context.Context) error {
+			return db.MustWriteContext(ctx, "dbtest_begin").Create(&user{ID: 4, Name: "dave"}).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if us := users(t, "dbtest_begin", false); len(us) != 4 {
+			t.Fatalf("expect writes in the pinned tx, got %+v", us)
+		}
+	})
+
+	if us := users(t, "dbtest_begin", false); len(us) != 0 {
+		t.Fatalf("expect rolled back after the test, got %+v", us)
+	}
+}
+
+func TestBeginInvalidatesCache(t *testing.T) {
+	f := writeFile(t, "users.yaml", "users:\n  - id: 1\n    name: bob\n")
+	d := openUsers(t, "dbtest_begin_cache").Fixtures(f)
+	db.MustWrite("dbtest_begin_cache").Create(&user{ID: 9, Name: "zed"})
+	users(t, "dbtest_begin_cache", true)
+
+	t.Run("tx", func(t *testing.T) {
+		d.Begin(t)
+		// 事务中不读缓存
+		if us := users(t, "dbtest_begin_cache", true); len(us) != 2 {
+			t.Fatalf("expect fixtures visible in tx, got %+v", us)
+		}
+		db.MustWrite("dbtest_begin_cache").Model(&user{ID: 9}).Update("name", "amy")
+	})
+
+	s := db.CacheStat()
+	if us := users(t, "dbtest_begin_cache", true); len(us) != 1 || us[0].Name != "zed" {
+		t.Fatalf("expect the data before Begin, got %+v", us)
+	}
+	if db.CacheStat().Misses != s.Misses+1 {
+		t.Fatal("expect the tables written in tx invalidated")
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	d := openUsers(t, "dbtest_fixture")
+
+	// json 与 yaml 的表名与列名都按小写处理
+	j := writeFile(t, "users.json", `{"Users": [{"ID": 1, "Name": "bob"}, {"id": 2, "NAME": "alice"}]}`)
+	y := writeFile(t, "users.yaml", "USERS:\n  - Id: 3\n    Name: carol\n")
+	if err := d.Load(j, y); err != nil {
+		t.Fatal(err)
+	}
+	us := users(t, "dbtest_fixture", false)
+	if len(us) != 3 || us[0].Name != "bob" || us[1].Name != "alice" || us[2].Name != "carol" {
+		t.Fatalf("expect fixtures loaded, got %+v", us)
+	}
+
+	if row, _ := toMap(map[string]interface{}{"Name": "bob"}); row["name"] != "bob" {
+		t.Fatalf("expect json keys lowercased, got %+v", row)
+	}
+
+	bad := writeFile(t, "bad.yaml", "users:\n  id: 1\n")
+	if err := d.Load(bad); err == nil {
+		t.Fatal("expect error for a table not being a list")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	d := openUsers(t, "dbtest_truncate")
+	if err := d.Load(writeFile(t, "users.yaml", "users:\n  - id: 1\n    name: bob\n")); err != nil {
+		t.Fatal(err)
+	}
+	if us := users(t, "dbtest_truncate", true); len(us) != 1 {
+		t.Fatalf("expect loaded rows, got %+v", us)
+	}
+	if err := d.Truncate("users"); err != nil {
+		t.Fatal(err)
+	}
+	if us := users(t, "dbtest_truncate", true); len(us) != 0 {
+		t.Fatalf("expect cache invalidated by Truncate, got %+v", us)
+	}
+}
@@ -0,0 +1,42 @@
+package engineer
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	pendingCMD func() error
+)
+
+// RegisterCommand 注册子命令, 子命令在 Start 时, 组件初始化之后执行, 执行完退出
+func RegisterCommand(c *cobra.Command) {
+	deferCommand(c)
+	appCMD.AddCommand(c)
+}
+
+func deferCommand(c *cobra.Command) {
+	if f := c.RunE; f != nil {
+		c.RunE = func(cmd *cobra.Command, args []string) error {
+			pendingCMD = func() error { return f(cmd, args) }
+			return nil
+		}
+	} else if f := c.Run; f != nil {
+		c.Run = func(cmd *cobra.Command, args []string) {
+			pendingCMD = func() error {
+				f(cmd, args)
+				return nil
+			}
+		}
+	}
+	for _, sub := range c.Commands() {
+		deferCommand(sub)
+	}
+}
+
+func runCommand() {
+	if err := pendingCMD(); err != nil {
+		enginerLogger.Error("command : ", err)
+		exit(1)
+	}
+	exit(0)
+}
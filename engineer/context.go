@@ -0,0 +1,18 @@
+package engineer
+
+import "context"
+
+type requestIDKey struct{}
+
+// WithRequestID 将请求 ID 放入 ctx, webserver 与 db 等组件通过 RequestID 取得
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,13 +25,17 @@ var (
 	pName         string
 	ec            = make(chan struct{})
 	enginerLogger = GetLogger("enginer")
+
+	shutdownHooks []func()
+	shutdownMu    sync.Mutex
+	shutdownOnce  sync.Once
 )
 
 func init() {
 
 	pName = os.Args[0]
 
-	appCMD = &cobra.Command{Use: pName}
+	appCMD = &cobra.Command{Use: pName, Run: func(*cobra.Command, []string) {}}
 
 	daemon = BindCMDArgsBool("daemon", false, "daemon the process")
 	forever = BindCMDArgsBool("forever", false, "forever the process")
@@ -168,6 +173,10 @@ func beForever() bool {
 
 func Start() {
 
+	if pendingCMD != nil {
+		runCommand()
+	}
+
 	beDaemon()
 
 	if !beForever() {
@@ -196,7 +205,26 @@ func Stop() {
 	}
 }
 
+// OnShutdown 注册进程退出前执行的清理, 按注册的相反顺序执行
+func OnShutdown(f func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownHooks = append(shutdownHooks, f)
+}
+
+func shutdown() {
+	shutdownOnce.Do(func() {
+		shutdownMu.Lock()
+		hooks := shutdownHooks
+		shutdownMu.Unlock()
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i]()
+		}
+	})
+}
+
 func exit(i int) {
+	shutdown()
 	os.Exit(i)
 }
 
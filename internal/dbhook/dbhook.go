@@ -0,0 +1,19 @@
+package dbhook
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+/**
+
+db 包内部功能的入口, 由 db 在 init 中设置, 仅供 dbtest 使用
+
+*/
+
+var (
+	// Pin 使 name 连接的 Read/Write 都返回 d, 返回的函数恢复之前的连接并失效固定期间写入的表的查询缓存
+	Pin func(name string, d *gorm.DB) (func(), error)
+
+	// Invalidate 失效 name 连接中表的查询缓存
+	Invalidate func(name string, tables ...string) error
+)
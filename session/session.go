@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
+	"github.com/joetang09/goengineer/db"
 )
 
 const (
@@ -93,7 +94,7 @@ func NewDBStore(f func() *gorm.DB) (*dbStore, error) {
 		return nil, dbGetterNotFoundErr
 	}
 	if !f().HasTable(sessionTableName) {
-		f().Set("gorm:table_options", "ENGINE=InnoDB").CreateTable(&StoreData{})
+		f().Scopes(db.TableOptions).CreateTable(&StoreData{})
 	}
 	return &dbStore{DBGetter: f}, nil
 }
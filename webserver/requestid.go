@@ -0,0 +1,53 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joetang09/goengineer/engineer"
+)
+
+const (
+	RequestIDHeader     = "X-Request-ID"
+	contextRequestIDKey = "_request_id"
+)
+
+// RequestIDMiddleware 使用请求头中的 X-Request-ID, 没有时生成, 并写入响应头与请求的 context
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(contextRequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return engineer.WithRequestID(ctx, id)
+}
+
+// RequestID 从请求的 context 或 *gin.Context 中取得请求 ID
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id := engineer.RequestID(ctx); id != "" {
+		return id
+	}
+	if id, ok := ctx.Value(contextRequestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}